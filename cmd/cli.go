@@ -4,20 +4,54 @@ import (
 	"context"
 	"flag"
 	"github.com/hazcod/one2sen/config"
+	"github.com/hazcod/one2sen/pkg/checkpoint"
+	"github.com/hazcod/one2sen/pkg/dedup"
+	"github.com/hazcod/one2sen/pkg/export"
 	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/secret"
 	msSentinel "github.com/hazcod/one2sen/pkg/sentinel"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/spool"
 	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 )
 
 func main() {
-	ctx := context.Background()
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
 
 	logger := logrus.New()
 	logger.SetLevel(logrus.InfoLevel)
 
 	confFile := flag.String("config", "config.yml", "The YAML configuration file.")
+	dryRun := flag.Bool("dry-run", false, "Write the logs that would be uploaded to the output instead of Sentinel.")
+	output := flag.String("output", "-", "Where the export or dry-run writes NDJSON to, - for stdout.")
+	compress := flag.Bool("gzip", false, "Gzip compress the export or dry-run output.")
 	flag.Parse()
 
+	// allow flags both before and after the command, e.g. one2sen serve -config=config.yml
+	command := "run"
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+		if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+			logger.WithError(err).Fatal("invalid arguments")
+		}
+	}
+
+	switch command {
+	case "run", "serve", "replay", "export", "provision":
+	default:
+		logger.WithField("command", command).Fatal("unknown command, use run, serve, replay, export or provision")
+	}
+
+	// an export is a dry-run which neither needs Azure nor touches the checkpoints
+	if command == "export" {
+		*dryRun = true
+	}
+
 	conf := config.Config{}
 	if err := conf.Load(*confFile); err != nil {
 		logger.WithError(err).WithField("config", *confFile).Fatal("failed to load configuration")
@@ -34,89 +68,179 @@ func main() {
 	}
 	logger.SetLevel(logrusLevel)
 
+	secrets := secret.NewResolver(secret.Options{
+		ConnectURL:   conf.Secrets.Connect.URL,
+		ConnectToken: conf.Secrets.Connect.Token,
+		Refresh:      conf.Secrets.Refresh,
+	})
+
 	//
 
-	onePass, err := onepassword.New(logger, conf.OnePassword.EventsURL, conf.OnePassword.ApiToken)
-	if err != nil {
-		logger.WithError(err).Fatal("could not create onepassword client")
+	var sentinel *msSentinel.Sentinel
+	var deadLetters *spool.Spool
+
+	// the Sentinel client is only needed when it is used as a sink or to provision
+	useSentinel := !*dryRun && slices.Contains(conf.Sinks, config.SinkSentinel)
+	if command == "provision" {
+		useSentinel = !*dryRun
 	}
 
-	sentinel, err := msSentinel.New(logger, msSentinel.Credentials{
-		TenantID:       conf.Microsoft.TenantID,
-		ClientID:       conf.Microsoft.AppID,
-		ClientSecret:   conf.Microsoft.SecretKey,
-		SubscriptionID: conf.Microsoft.SubscriptionID,
-		ResourceGroup:  conf.Microsoft.ResourceGroup,
-		WorkspaceName:  conf.Microsoft.WorkspaceName,
-	})
-	if err != nil {
-		logger.WithError(err).Fatal("could not create MS Sentinel client")
+	if useSentinel {
+		sentinel, err = msSentinel.New(logger, msSentinel.Credentials{
+			AuthMethod:          msSentinel.AuthMethod(conf.Microsoft.Auth),
+			TenantID:            conf.Microsoft.TenantID,
+			ClientID:            conf.Microsoft.AppID,
+			ClientSecret:        optionalSecret(secrets, conf.Microsoft.SecretKey),
+			CertificatePath:     conf.Microsoft.CertificatePath,
+			CertificatePassword: optionalSecret(secrets, conf.Microsoft.CertificatePassword),
+			FederatedTokenFile:  conf.Microsoft.FederatedTokenFile,
+			SubscriptionID:      conf.Microsoft.SubscriptionID,
+			ResourceGroup:       conf.Microsoft.ResourceGroup,
+			WorkspaceName:       conf.Microsoft.WorkspaceName,
+		}, msSentinel.Options{
+			UploadWorkers: conf.Microsoft.UploadWorkers,
+		})
+		if err != nil {
+			logger.WithError(err).Fatal("could not create MS Sentinel client")
+		}
+	}
+
+	if conf.Spool.Dir != "" && !*dryRun {
+		if deadLetters, err = spool.New(conf.Spool.Dir); err != nil {
+			logger.WithError(err).Fatal("could not open spool directory")
+		}
 	}
 
 	//
 
-	if conf.Microsoft.UpdateTable {
-		if err := sentinel.CreateTable(ctx, logger, conf.Microsoft.RetentionDays); err != nil {
-			logger.WithError(err).Fatal("failed to create MS Sentinel table")
+	if command == "replay" {
+		if deadLetters == nil {
+			logger.Fatal("no spool directory configured to replay from")
+		}
+
+		sinks, err := newSinks(logger, &conf, secrets, sentinel, deadLetters)
+		if err != nil {
+			logger.WithError(err).Fatal("could not create sinks")
+		}
+
+		err = replay(ctx, logger, sentinel, sinks.Sinks, deadLetters)
+
+		if closeErr := sinks.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			logger.WithError(err).Fatal("could not replay all spooled logs")
+		}
+
+		return
+	}
+
+	if command == "provision" {
+		if sentinel == nil {
+			logger.Fatal("provisioning cannot be combined with a dry-run")
+		}
+
+		if err := provision(ctx, logger, &conf, sentinel); err != nil {
+			logger.WithError(err).Fatal("could not provision MS Sentinel")
 		}
+
+		return
 	}
 
 	//
 
-	logger.WithField("duration", conf.OnePassword.Lookback.String()).Info("Retrieving 1P logs")
+	accounts := make([]*account, len(conf.OnePassword.Accounts))
+	for i, acct := range conf.OnePassword.Accounts {
+		client, err := onepassword.New(logger, acct.EventsURL, secrets.Func(acct.ApiToken))
+		if err != nil {
+			logger.WithError(err).WithField("account", acct.Name).Fatal("could not create onepassword client")
+		}
 
-	signinEvents, err := onePass.GetSigninEvents(conf.OnePassword.Lookback)
-	if err != nil {
-		logger.WithError(err).Fatal("could not fetch onepassword signin events")
+		accounts[i] = &account{Account: acct, client: client}
 	}
 
-	signinLogs, err := onepassword.ConvertSigninToMap(logger, signinEvents)
-	if err != nil {
-		logger.WithError(err).Errorf("could not parse signin events")
+	if conf.Microsoft.UpdateTable && sentinel != nil {
+		for _, stream := range sentinelStreams(&conf) {
+			if err := sentinel.CreateTable(ctx, logger, stream.Table, conf.Microsoft.RetentionDays); err != nil {
+				logger.WithError(err).WithField("table", stream.Table.Name).Fatal("failed to create MS Sentinel table")
+			}
+		}
 	}
 
 	//
 
-	usageEvents, err := onePass.GetUsage(conf.OnePassword.Lookback)
-	if err != nil {
-		logger.WithError(err).Fatal("could not fetch onepassword usage events")
+	checkpointPath := conf.Checkpoint.Path
+	if *dryRun {
+		// keep checkpoints in memory so a dry-run never affects the next real run
+		checkpointPath = ""
 	}
 
-	usageLogs, err := onepassword.ConvertUsageToMap(logger, usageEvents)
+	checkpoints, err := checkpoint.New(checkpointPath)
 	if err != nil {
-		logger.WithError(err).Error("could not parse usage logs")
+		logger.WithError(err).Fatal("could not load checkpoints")
 	}
 
-	//
+	var seen *dedup.Set
+	if conf.Dedup.Path != "" {
+		dedupPath := conf.Dedup.Path
+		if *dryRun {
+			// only deduplicate within the dry-run itself
+			dedupPath = ""
+		}
 
-	auditEvents, err := onePass.GetAuditEvents(conf.OnePassword.Lookback)
-	if err != nil {
-		logger.WithError(err).Fatal("could not fetch onepassword audit events")
+		if seen, err = dedup.New(dedupPath, conf.Dedup.Window, conf.Dedup.MaxEntries); err != nil {
+			logger.WithError(err).Fatal("could not load dedup set")
+		}
 	}
 
-	auditLogs, err := onepassword.ConvertAuditEventToMap(logger, auditEvents)
-	if err != nil {
-		logger.WithError(err).Errorf("could not parse audit events")
+	var exporter *export.Exporter
+	var sinks sink.Sink
+
+	if *dryRun {
+		if exporter, err = export.New(*output, *compress); err != nil {
+			logger.WithError(err).Fatal("could not create export output")
+		}
+
+		sinks = exporter
+	} else if sinks, err = newSinks(logger, &conf, secrets, sentinel, deadLetters); err != nil {
+		logger.WithError(err).Fatal("could not create sinks")
 	}
 
-	//
+	c := &collector{
+		logger:      logger,
+		conf:        &conf,
+		accounts:    accounts,
+		sink:        sinks,
+		checkpoints: checkpoints,
+		seen:        seen,
+	}
 
-	allLogs := append(signinLogs, usageLogs...)
-	allLogs = append(allLogs, auditLogs...)
+	switch command {
+	case "run", "export":
+		err = c.Run(ctx)
+	case "serve":
+		serve(ctx, c, conf.Daemon.Interval, conf.Daemon.Jitter)
+	}
 
-	logger.WithField("total", len(allLogs)).Info("collected all 1Password logs")
+	if closeErr := sinks.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
 
-	//
+	if exporter != nil {
+		logger.WithField("total", exporter.Total()).WithField("output", *output).Info("exported logs")
+	}
 
-	if err := sentinel.SendLogs(ctx, logger,
-		conf.Microsoft.DataCollection.Endpoint,
-		conf.Microsoft.DataCollection.RuleID,
-		conf.Microsoft.DataCollection.StreamName,
-		allLogs); err != nil {
-		logger.WithError(err).Fatal("could not ship logs to sentinel")
+	if err != nil {
+		logger.WithError(err).Fatal("could not ship 1Password logs")
 	}
+}
 
-	//
+// optionalSecret returns nil for a secret which was not configured.
+func optionalSecret(secrets *secret.Resolver, ref string) secret.Func {
+	if ref == "" {
+		return nil
+	}
 
-	logger.WithField("total", len(allLogs)).Info("successfully sent logs to sentinel")
+	return secrets.Func(ref)
 }
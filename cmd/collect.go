@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/one2sen/config"
+	"github.com/hazcod/one2sen/pkg/checkpoint"
+	"github.com/hazcod/one2sen/pkg/dedup"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+)
+
+// account is a 1Password account together with the client to fetch its events.
+type account struct {
+	config.Account
+	client *onepassword.OnePassword
+}
+
+// collector ships a single round of 1Password events to the sinks and can be run repeatedly.
+type collector struct {
+	logger      *logrus.Logger
+	conf        *config.Config
+	accounts    []*account
+	sink        sink.Sink
+	checkpoints *checkpoint.Store
+
+	// events which were already shipped, nil when deduplication is disabled
+	seen *dedup.Set
+}
+
+// Run fetches all new 1Password events of every account and uploads them,
+// with every stream being fetched and shipped concurrently. The first failing stream cancels the others.
+// Cancelling ctx aborts fetching, but an upload that has already started is always finished.
+func (c *collector) Run(ctx context.Context) error {
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(c.conf.OnePassword.Concurrency)
+
+	for _, acct := range c.accounts {
+		c.logger.WithField("account", acct.Name).WithField("duration", acct.Lookback.String()).Info("Retrieving 1P logs")
+
+		for _, stream := range acct.Streams {
+			group.Go(func() error {
+				return c.shipAccountStream(groupCtx, acct, stream)
+			})
+		}
+	}
+
+	return group.Wait()
+}
+
+// shipAccountStream ships a single stream of acct with the converter matching the table layout.
+func (c *collector) shipAccountStream(ctx context.Context, acct *account, stream string) error {
+	perStream := c.conf.Microsoft.TableLayout == config.TableLayoutPerStream
+
+	switch stream {
+	case onepassword.StreamSignins:
+		if perStream {
+			return shipStream(ctx, c, acct, stream, onepassword.ConvertSigninToFlatMap)
+		}
+		return shipStream(ctx, c, acct, stream, onepassword.ConvertSigninToMap)
+
+	case onepassword.StreamUsage:
+		if perStream {
+			return shipStream(ctx, c, acct, stream, onepassword.ConvertUsageToFlatMap)
+		}
+		return shipStream(ctx, c, acct, stream, onepassword.ConvertUsageToMap)
+
+	case onepassword.StreamAudit:
+		if perStream {
+			return shipStream(ctx, c, acct, stream, onepassword.ConvertAuditEventToFlatMap)
+		}
+		return shipStream(ctx, c, acct, stream, onepassword.ConvertAuditEventToMap)
+
+	default:
+		return fmt.Errorf("unknown 1Password stream '%s'", stream)
+	}
+}
+
+// ship delivers a page of converted logs of a stream of acct to the sinks.
+func (c *collector) ship(ctx context.Context, acct *account, stream string, logs []record.Record) error {
+	// the upload is not tied to ctx so a shutdown never interrupts it halfway
+	return c.sink.Send(context.WithoutCancel(ctx), sink.Batch{
+		Account: acct.Name,
+		Stream:  stream,
+		Logs:    logs,
+	})
+}
+
+// checkpointKey identifies the checkpoint of a stream of acct.
+func checkpointKey(acct *account, stream string) string {
+	return acct.Name + "/" + stream
+}
+
+func (c *collector) loadCheckpoint(acct *account, stream string) checkpoint.Checkpoint {
+	logger := c.logger.WithField("account", acct.Name).WithField("stream", stream)
+
+	cp, ok := c.checkpoints.Get(checkpointKey(acct, stream))
+	if !ok && len(c.accounts) == 1 {
+		// checkpoints written before multiple accounts were supported are only keyed by stream
+		cp, ok = c.checkpoints.Get(stream)
+	}
+
+	if !ok || cp.Cursor == "" {
+		logger.Info("no checkpoint found, using lookback")
+		return checkpoint.Checkpoint{}
+	}
+
+	logger.WithField("last_event", cp.LastEventTime).Debug("resuming from checkpoint")
+
+	return cp
+}
+
+func (c *collector) saveCheckpoint(acct *account, stream string, cp checkpoint.Checkpoint) error {
+	if cp.Cursor == "" {
+		c.logger.WithField("account", acct.Name).WithField("stream", stream).Warn("no cursor returned, not advancing checkpoint")
+		return nil
+	}
+
+	if err := c.checkpoints.Set(checkpointKey(acct, stream), cp); err != nil {
+		return fmt.Errorf("could not save checkpoint for %s of %s: %v", stream, acct.Name, err)
+	}
+
+	return nil
+}
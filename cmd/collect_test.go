@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hazcod/one2sen/config"
+	"github.com/hazcod/one2sen/pkg/checkpoint"
+	"github.com/hazcod/one2sen/pkg/dedup"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// funcSink hands every batch to send.
+type funcSink struct {
+	send func(ctx context.Context, batch sink.Batch) error
+}
+
+func (s *funcSink) Name() string {
+	return "func"
+}
+
+func (s *funcSink) Send(ctx context.Context, batch sink.Batch) error {
+	return s.send(ctx, batch)
+}
+
+func (s *funcSink) Close() error {
+	return nil
+}
+
+// pageFunc answers a page request for stream, cursor is empty for the first page.
+type pageFunc func(r *http.Request, stream, cursor string) (events []string, nextCursor string, hasMore bool)
+
+// newEventsServer fakes the 1Password Events API, every event is returned with the uuid from events.
+func newEventsServer(t *testing.T, page pageFunc) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Cursor string `json:"cursor"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		events, cursor, hasMore := page(r, strings.TrimPrefix(r.URL.Path, "/api/v1/"), req.Cursor)
+		if events == nil && cursor == "" {
+			// the request was cancelled or is rejected
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		items := make([]map[string]any, 0, len(events))
+		for _, uuid := range events {
+			items = append(items, map[string]any{"uuid": uuid, "timestamp": "2024-01-02T03:04:05Z"})
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"cursor": cursor, "has_more": hasMore, "items": items})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestCollector(t *testing.T, logger *logrus.Logger, serverURL string, s sink.Sink, streams ...string) *collector {
+	t.Helper()
+
+	client, err := onepassword.New(logger, serverURL, secret.Static("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoints, err := checkpoint.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.Config{}
+	conf.OnePassword.Concurrency = len(streams)
+	conf.Microsoft.TableLayout = config.TableLayoutPerStream
+
+	return &collector{
+		logger: logger,
+		conf:   conf,
+		accounts: []*account{{
+			Account: config.Account{Name: "default", Lookback: time.Hour, Streams: streams},
+			client:  client,
+		}},
+		sink:        s,
+		checkpoints: checkpoints,
+	}
+}
+
+func TestCollector_Run_SinkFailure(t *testing.T) {
+	server := newEventsServer(t, func(r *http.Request, stream, _ string) ([]string, string, bool) {
+		if stream == onepassword.StreamAudit {
+			return []string{"a1"}, "audit-1", false
+		}
+
+		// the other streams are still fetching when the audit events fail to ship
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+
+		return nil, "", false
+	})
+
+	failure := errors.New("sink unavailable")
+	c := newTestCollector(t, logrus.New(), server.URL, &funcSink{send: func(context.Context, sink.Batch) error {
+		return failure
+	}}, onepassword.StreamSignins, onepassword.StreamUsage, onepassword.StreamAudit)
+
+	streams := []string{onepassword.StreamSignins, onepassword.StreamUsage, onepassword.StreamAudit}
+	for _, stream := range streams {
+		if err := c.checkpoints.Set("default/"+stream, checkpoint.Checkpoint{Cursor: stream + "-0"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	started := time.Now()
+
+	if err := c.Run(context.Background()); !errors.Is(err, failure) {
+		t.Fatalf("expected the sink failure, got %v", err)
+	}
+
+	// the failure cancelled the streams which were still fetching
+	if took := time.Since(started); took > 5*time.Second {
+		t.Fatalf("the other streams were not cancelled, run took %s", took)
+	}
+
+	for _, stream := range streams {
+		if cp, _ := c.checkpoints.Get("default/" + stream); cp.Cursor != stream+"-0" {
+			t.Fatalf("expected the checkpoint of %s to be left alone, got %+v", stream, cp)
+		}
+	}
+}
+
+func TestCollector_Run_CheckpointAfterSend(t *testing.T) {
+	server := newEventsServer(t, func(_ *http.Request, _, cursor string) ([]string, string, bool) {
+		if cursor == "" {
+			return []string{"a1"}, "audit-1", true
+		}
+
+		return []string{"a2"}, "audit-2", false
+	})
+
+	var c *collector
+	var checkpointsDuringSend []string
+
+	c = newTestCollector(t, logrus.New(), server.URL, &funcSink{send: func(_ context.Context, batch sink.Batch) error {
+		// the checkpoint still points before the page which is being sent
+		cp, _ := c.checkpoints.Get("default/" + batch.Stream)
+		checkpointsDuringSend = append(checkpointsDuringSend, cp.Cursor)
+
+		return nil
+	}}, onepassword.StreamAudit)
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(checkpointsDuringSend) != 2 || checkpointsDuringSend[0] != "" || checkpointsDuringSend[1] != "audit-1" {
+		t.Fatalf("expected the checkpoint to advance after every sent page, got %v", checkpointsDuringSend)
+	}
+
+	if cp, _ := c.checkpoints.Get("default/" + onepassword.StreamAudit); cp.Cursor != "audit-2" {
+		t.Fatalf("expected the checkpoint at the last page, got %+v", cp)
+	}
+}
+
+func TestCollector_Run_Dedup(t *testing.T) {
+	// every run returns the same events, as after a lost checkpoint
+	server := newEventsServer(t, func(_ *http.Request, _, _ string) ([]string, string, bool) {
+		return []string{"a1", "a2"}, "audit-1", false
+	})
+
+	path := filepath.Join(t.TempDir(), "seen.jsonl")
+
+	var sent []string
+	send := &funcSink{send: func(_ context.Context, batch sink.Batch) error {
+		for _, log := range batch.Logs {
+			sent = append(sent, log["UUID"].(string))
+		}
+
+		return nil
+	}}
+
+	for run := 1; run <= 2; run++ {
+		logger, hook := logtest.NewNullLogger()
+
+		c := newTestCollector(t, logger, server.URL, send, onepassword.StreamAudit)
+
+		// the events of 2024 stay within the window
+		seen, err := dedup.New(path, 24*365*100*time.Hour, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.seen = seen
+
+		if err := c.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		var shipped *logrus.Entry
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "successfully shipped logs" {
+				shipped = entry
+			}
+		}
+
+		// the second run suppresses everything the first run shipped
+		wantTotal, wantSuppressed := 2, 0
+		if run == 2 {
+			wantTotal, wantSuppressed = 0, 2
+		}
+
+		if shipped == nil || shipped.Data["total"] != wantTotal || shipped.Data["suppressed"] != wantSuppressed {
+			t.Fatalf("run %d: unexpected totals: %v", run, shipped)
+		}
+	}
+
+	if len(sent) != 2 || sent[0] != "a1" || sent[1] != "a2" {
+		t.Fatalf("expected every event to be sent once, got %v", sent)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/checkpoint"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"time"
+)
+
+const (
+	// how many pages may wait between two pipeline stages before the previous stage blocks
+	pipelineBuffer = 2
+)
+
+type rawPage[T any] struct {
+	events []T
+	cursor string
+}
+
+type convertedPage struct {
+	logs   []record.Record
+	cursor string
+	latest time.Time
+
+	// ids and event times of the converted events, remembered once they were shipped
+	ids        []string
+	eventTimes []time.Time
+}
+
+// shipStream streams all new events of a single stream of acct from 1Password through the converter into the sinks.
+// Pages flow through bounded channels so memory stays constant, and the checkpoint advances after every uploaded page.
+func shipStream[T onepassword.APIEvent](ctx context.Context, c *collector, acct *account, stream string, convert func(*logrus.Logger, []T) ([]record.Record, error)) error {
+	logger := c.logger.WithField("account", acct.Name).WithField("stream", stream)
+
+	cp := c.loadCheckpoint(acct, stream)
+
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	rawPages := make(chan rawPage[T], pipelineBuffer)
+	convertedPages := make(chan convertedPage, pipelineBuffer)
+
+	group.Go(func() error {
+		defer close(rawPages)
+
+		_, err := onepassword.Paginate(groupCtx, acct.client, stream, acct.Lookback, cp.Cursor, func(events []T, cursor string) error {
+			select {
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			case rawPages <- rawPage[T]{events: events, cursor: cursor}:
+				return nil
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("could not fetch onepassword %s events: %w", stream, err)
+		}
+
+		return nil
+	})
+
+	suppressed := 0
+
+	group.Go(func() error {
+		defer close(convertedPages)
+
+		for page := range rawPages {
+			latest := onepassword.LatestEventTime(time.Time{}, page.events)
+
+			events, ids, eventTimes := dropSeen(c, acct, page.events)
+			suppressed += len(page.events) - len(events)
+
+			logs, err := convert(c.logger, events)
+			if err != nil {
+				return fmt.Errorf("could not parse %s events: %v", stream, err)
+			}
+
+			for _, log := range logs {
+				log[onepassword.AccountColumn] = acct.Name
+			}
+
+			if c.conf.OnePassword.RawEvent {
+				onepassword.AddRawEvents(logs, events)
+			}
+
+			select {
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			case convertedPages <- convertedPage{
+				logs:       logs,
+				cursor:     page.cursor,
+				latest:     latest,
+				ids:        ids,
+				eventTimes: eventTimes,
+			}:
+			}
+		}
+
+		return nil
+	})
+
+	total := 0
+
+	group.Go(func() error {
+		for page := range convertedPages {
+			if err := groupCtx.Err(); err != nil {
+				return err
+			}
+
+			if len(page.logs) > 0 {
+				if err := c.ship(groupCtx, acct, stream, page.logs); err != nil {
+					return err
+				}
+
+				total += len(page.logs)
+			}
+
+			if c.seen != nil {
+				if err := c.seen.Add(page.ids, page.eventTimes); err != nil {
+					return err
+				}
+			}
+
+			if page.latest.After(cp.LastEventTime) {
+				cp.LastEventTime = page.latest
+			}
+			cp.Cursor = page.cursor
+
+			// only advance the checkpoint once the page has been confirmed as uploaded
+			if err := c.saveCheckpoint(acct, stream, checkpoint.Checkpoint{
+				Cursor:        cp.Cursor,
+				LastEventTime: cp.LastEventTime,
+			}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	logger.WithField("total", total).WithField("suppressed", suppressed).Info("successfully shipped logs")
+
+	return nil
+}
+
+// dropSeen removes the events of acct which were already shipped, including duplicates within events.
+// It returns the remaining events together with their dedup ids and event times.
+func dropSeen[T onepassword.APIEvent](c *collector, acct *account, events []T) ([]T, []string, []time.Time) {
+	if c.seen == nil {
+		return events, nil, nil
+	}
+
+	kept := make([]T, 0, len(events))
+	ids := make([]string, 0, len(events))
+	eventTimes := make([]time.Time, 0, len(events))
+
+	inPage := make(map[string]bool, len(events))
+
+	for _, event := range events {
+		if event.EventID() == "" {
+			kept = append(kept, event)
+			continue
+		}
+
+		id := acct.Name + "/" + event.EventID()
+		if inPage[id] || c.seen.Seen(id) {
+			continue
+		}
+		inPage[id] = true
+
+		eventTime, err := event.EventTime()
+		if err != nil {
+			eventTime = time.Now()
+		}
+
+		kept = append(kept, event)
+		ids = append(ids, id)
+		eventTimes = append(eventTimes, eventTime)
+	}
+
+	return kept, ids, eventTimes
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/one2sen/config"
+	msSentinel "github.com/hazcod/one2sen/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"os"
+)
+
+// sentinelStreams returns the data collection rule streams and their tables for the configured table layout.
+func sentinelStreams(conf *config.Config) []msSentinel.Stream {
+	dcr := conf.Microsoft.DataCollection
+
+	if conf.Microsoft.TableLayout == config.TableLayoutPerStream {
+		return []msSentinel.Stream{
+			{Name: dcr.SigninStreamName, Table: msSentinel.SigninTable},
+			{Name: dcr.UsageStreamName, Table: msSentinel.UsageTable},
+			{Name: dcr.AuditStreamName, Table: msSentinel.AuditTable},
+		}
+	}
+
+	return []msSentinel.Stream{{Name: dcr.StreamName, Table: msSentinel.LegacyTable}}
+}
+
+// provision creates the tables, data collection endpoint and rule for the configured table layout,
+// and prints the resulting dcr configuration.
+func provision(ctx context.Context, logger *logrus.Logger, conf *config.Config, sentinel *msSentinel.Sentinel) error {
+	streams := sentinelStreams(conf)
+
+	// the data collection rule can only send to tables which already exist
+	for _, stream := range streams {
+		if err := sentinel.CreateTable(ctx, logger, stream.Table, conf.Microsoft.RetentionDays); err != nil {
+			return fmt.Errorf("could not create table '%s': %v", stream.Table.Name, err)
+		}
+	}
+
+	provisioned, err := sentinel.Provision(ctx, logger, msSentinel.Provisioning{
+		Location:     conf.Microsoft.DataCollection.Location,
+		EndpointName: conf.Microsoft.DataCollection.EndpointName,
+		RuleName:     conf.Microsoft.DataCollection.RuleName,
+		Streams:      streams,
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("endpoint", provisioned.Endpoint).WithField("rule_id", provisioned.RuleID).
+		Info("provisioned data collection endpoint and rule")
+
+	dcr := map[string]string{
+		"endpoint": provisioned.Endpoint,
+		"rule_id":  provisioned.RuleID,
+	}
+	for _, stream := range streams {
+		switch stream.Table.Name {
+		case msSentinel.SigninTable.Name:
+			dcr["signin_stream_name"] = stream.Name
+		case msSentinel.UsageTable.Name:
+			dcr["usage_stream_name"] = stream.Name
+		case msSentinel.AuditTable.Name:
+			dcr["audit_stream_name"] = stream.Name
+		default:
+			dcr["stream_name"] = stream.Name
+		}
+	}
+
+	// print the settings in the same shape as the configuration file so they can be copied over
+	encoder := yaml.NewEncoder(os.Stdout)
+	defer encoder.Close()
+
+	return encoder.Encode(map[string]any{
+		"microsoft": map[string]any{"dcr": dcr},
+	})
+}
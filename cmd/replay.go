@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	msSentinel "github.com/hazcod/one2sen/pkg/sentinel"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/spool"
+	"github.com/sirupsen/logrus"
+)
+
+// replay re-sends all spooled batches and removes them once uploaded.
+// Pages which a sink failed to accept are only sent to that sink.
+// Sentinel batches that still fail are spooled again without the logs that did make it, so nothing is sent twice.
+func replay(ctx context.Context, logger *logrus.Logger, sentinel *msSentinel.Sentinel, sinks []sink.Sink, deadLetters *spool.Spool) error {
+	paths, err := deadLetters.List()
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("total", len(paths)).Info("replaying spooled logs")
+
+	failed := 0
+	replayed := 0
+
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fileLogger := logger.WithField("path", path)
+
+		meta, logs, err := deadLetters.Read(path)
+		if err != nil {
+			fileLogger.WithError(err).Error("could not read spooled logs")
+			failed++
+			continue
+		}
+
+		if meta.Sink != "" {
+			if err := replayToSink(ctx, sinks, meta, logs); err != nil {
+				fileLogger.WithError(err).WithField("sink", meta.Sink).Error("could not replay spooled logs")
+				failed++
+				continue
+			}
+
+			replayed++
+		} else {
+			if sentinel == nil {
+				fileLogger.Error("could not replay spooled logs, sentinel is not configured as a sink")
+				failed++
+				continue
+			}
+
+			result, err := sentinel.SendLogs(ctx, logger, meta.Endpoint, meta.RuleID, meta.StreamName, logs)
+			if err != nil {
+				if result == nil || len(result.Failed) == 0 {
+					fileLogger.WithError(err).Error("could not replay spooled logs")
+					failed++
+					continue
+				}
+
+				if err := respool(deadLetters, meta, result); err != nil {
+					fileLogger.WithError(err).Error("could not spool logs that failed again")
+					failed++
+					continue
+				}
+
+				fileLogger.WithError(err).WithField("failed", len(result.Failed)).Warn("spooled logs partially replayed")
+				failed++
+			} else {
+				replayed++
+			}
+		}
+
+		if err := deadLetters.Remove(path); err != nil {
+			fileLogger.WithError(err).Error("could not remove replayed spool file")
+			failed++
+		}
+	}
+
+	logger.WithField("replayed", replayed).WithField("failed", failed).Info("finished replaying spooled logs")
+
+	if failed > 0 {
+		return fmt.Errorf("%d spool files could not be replayed", failed)
+	}
+
+	return nil
+}
+
+// replayToSink sends a spooled page to the sink which failed to accept it.
+func replayToSink(ctx context.Context, sinks []sink.Sink, meta spool.Metadata, logs []record.Record) error {
+	for _, s := range sinks {
+		if s.Name() == meta.Sink {
+			return s.Send(ctx, sink.Batch{Account: meta.Account, Stream: meta.Stream, Logs: logs})
+		}
+	}
+
+	return errors.New("the sink is no longer configured")
+}
+
+func respool(deadLetters *spool.Spool, meta spool.Metadata, result *msSentinel.SendResult) error {
+	for _, failed := range result.Failed {
+		if _, err := deadLetters.Write(spool.Metadata{
+			Endpoint:   meta.Endpoint,
+			RuleID:     meta.RuleID,
+			StreamName: meta.StreamName,
+			Error:      failed.Err.Error(),
+		}, failed.Logs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"math/rand"
+	"time"
+)
+
+// serve runs the collector on an interval until ctx is cancelled.
+// Failed rounds are logged and retried on the next tick instead of stopping the daemon.
+func serve(ctx context.Context, c *collector, interval, jitter time.Duration) {
+	logger := c.logger.WithField("module", "daemon")
+
+	logger.WithField("interval", interval.String()).WithField("jitter", jitter.String()).Info("starting daemon")
+
+	for {
+		started := time.Now()
+
+		if err := c.Run(ctx); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+
+			if errors.Is(err, onepassword.ErrUnauthorized) || errors.Is(err, onepassword.ErrForbidden) {
+				logger.WithError(err).Error("1Password rejected the api token, check its validity and scopes")
+			} else {
+				logger.WithError(err).Error("collection round failed, retrying next round")
+			}
+		} else {
+			logger.WithField("took", time.Since(started).String()).Info("finished collection round")
+		}
+
+		wait := nextRun(interval, jitter)
+		logger.WithField("next_run", time.Now().Add(wait).Format(time.RFC3339)).Debug("waiting for next round")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("shutting down daemon")
+			return
+		case <-timer.C:
+		}
+	}
+
+	logger.Info("shutting down daemon")
+}
+
+// nextRun returns the interval plus a random jitter so multiple instances do not poll in lockstep.
+func nextRun(interval, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int63n(int64(jitter)))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe_FailedRound(t *testing.T) {
+	server := newEventsServer(t, func(*http.Request, string, string) ([]string, string, bool) {
+		return []string{"a1"}, "audit-1", false
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sends := 0
+	logger, hook := logtest.NewNullLogger()
+
+	c := newTestCollector(t, logger, server.URL, &funcSink{send: func(context.Context, sink.Batch) error {
+		sends++
+		if sends == 1 {
+			return errors.New("sink unavailable")
+		}
+
+		// the second round succeeded, stop the daemon
+		cancel()
+		return nil
+	}}, onepassword.StreamAudit)
+
+	done := make(chan struct{})
+	go func() {
+		serve(ctx, c, 10*time.Millisecond, 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not stop")
+	}
+
+	if sends != 2 {
+		t.Fatalf("expected a second round after the failure, got %d sends", sends)
+	}
+
+	var failed bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Level == logrus.ErrorLevel && entry.Message == "collection round failed, retrying next round" {
+			failed = true
+		}
+	}
+
+	if !failed {
+		t.Fatal("expected the failed round to be logged")
+	}
+}
+
+func TestServe_CancelFinishesRound(t *testing.T) {
+	server := newEventsServer(t, func(*http.Request, string, string) ([]string, string, bool) {
+		return []string{"a1"}, "audit-1", false
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	sendErr := make(chan error, 1)
+
+	c := newTestCollector(t, logrus.New(), server.URL, &funcSink{send: func(sendCtx context.Context, _ sink.Batch) error {
+		close(sending)
+		<-release
+
+		// the upload outlives the cancelled daemon
+		sendErr <- sendCtx.Err()
+		return nil
+	}}, onepassword.StreamAudit)
+
+	done := make(chan struct{})
+	go func() {
+		serve(ctx, c, time.Hour, 0)
+		close(done)
+	}()
+
+	<-sending
+	cancel()
+
+	select {
+	case <-done:
+		t.Fatal("the daemon returned before the in-flight round finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the daemon did not stop")
+	}
+
+	if err := <-sendErr; err != nil {
+		t.Fatalf("expected the upload context to survive the shutdown, got %v", err)
+	}
+
+	if cp, _ := c.checkpoints.Get("default/" + onepassword.StreamAudit); cp.Cursor != "audit-1" {
+		t.Fatalf("expected the checkpoint of the finished round, got %+v", cp)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	if got := nextRun(time.Minute, 0); got != time.Minute {
+		t.Fatalf("expected the interval without jitter, got %s", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		if got := nextRun(time.Minute, time.Second); got < time.Minute || got >= time.Minute+time.Second {
+			t.Fatalf("expected the jitter to stay within a second, got %s", got)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/config"
+	"github.com/hazcod/one2sen/pkg/archive"
+	"github.com/hazcod/one2sen/pkg/elastic"
+	"github.com/hazcod/one2sen/pkg/kafka"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/otlp"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/secret"
+	msSentinel "github.com/hazcod/one2sen/pkg/sentinel"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/splunk"
+	"github.com/hazcod/one2sen/pkg/spool"
+	"github.com/hazcod/one2sen/pkg/syslog"
+	"github.com/hazcod/one2sen/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// newSinks creates every sink selected in the configuration.
+// With a spool, a page which only some of the sinks failed is spooled for each of them instead of failing it.
+func newSinks(logger *logrus.Logger, conf *config.Config, secrets *secret.Resolver, sentinel *msSentinel.Sentinel, deadLetters *spool.Spool) (*sink.Multi, error) {
+	sinks := make([]sink.Sink, 0, len(conf.Sinks))
+
+	for _, name := range conf.Sinks {
+		switch name {
+		case config.SinkSentinel:
+			sinks = append(sinks, &sentinelSink{
+				logger:      logger,
+				conf:        conf,
+				sentinel:    sentinel,
+				deadLetters: deadLetters,
+			})
+
+		case config.SinkSplunk:
+			hec, err := splunk.New(logger, splunk.Options{
+				URL:        conf.Splunk.URL,
+				Token:      secrets.Func(conf.Splunk.Token),
+				Index:      conf.Splunk.Index,
+				Source:     conf.Splunk.Source,
+				SourceType: conf.Splunk.SourceType,
+				Host:       conf.Splunk.Host,
+				Ack:        conf.Splunk.Ack,
+				AckTimeout: conf.Splunk.AckTimeout,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create splunk sink: %v", err)
+			}
+
+			sinks = append(sinks, hec)
+
+		case config.SinkElasticsearch:
+			bulk, err := elastic.New(logger, elastic.Options{
+				URL:        conf.Elasticsearch.URL,
+				Username:   conf.Elasticsearch.Username,
+				Password:   optionalSecret(secrets, conf.Elasticsearch.Password),
+				APIKey:     optionalSecret(secrets, conf.Elasticsearch.APIKey),
+				Index:      conf.Elasticsearch.Index,
+				DataStream: conf.Elasticsearch.DataStream,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create elasticsearch sink: %v", err)
+			}
+
+			sinks = append(sinks, bulk)
+
+		case config.SinkSyslog:
+			writer, err := syslog.New(logger, syslog.Options{
+				Network:    conf.Syslog.Network,
+				Address:    conf.Syslog.Address,
+				Format:     conf.Syslog.Format,
+				Framing:    conf.Syslog.Framing,
+				Facility:   conf.Syslog.Facility,
+				Hostname:   conf.Syslog.Hostname,
+				CAFile:     conf.Syslog.TLS.CAFile,
+				CertFile:   conf.Syslog.TLS.CertFile,
+				KeyFile:    conf.Syslog.TLS.KeyFile,
+				ServerName: conf.Syslog.TLS.ServerName,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create syslog sink: %v", err)
+			}
+
+			sinks = append(sinks, writer)
+
+		case config.SinkKafka:
+			producer, err := kafka.New(logger, kafka.Options{
+				Brokers:          conf.Kafka.Brokers,
+				Topic:            conf.Kafka.Topic,
+				PartitionByActor: conf.Kafka.PartitionByActor,
+				SASLMechanism:    conf.Kafka.SASL.Mechanism,
+				Username:         conf.Kafka.SASL.Username,
+				Password:         optionalSecret(secrets, conf.Kafka.SASL.Password),
+				TLS:              conf.Kafka.TLS.Enabled,
+				CAFile:           conf.Kafka.TLS.CAFile,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create kafka sink: %v", err)
+			}
+
+			sinks = append(sinks, producer)
+
+		case config.SinkS3:
+			s3, err := archive.New(logger, archive.Options{
+				Endpoint:        conf.S3.Endpoint,
+				Bucket:          conf.S3.Bucket,
+				Region:          conf.S3.Region,
+				Prefix:          conf.S3.Prefix,
+				PathStyle:       conf.S3.PathStyle,
+				AccessKeyID:     conf.S3.AccessKeyID,
+				SecretAccessKey: optionalSecret(secrets, conf.S3.SecretAccessKey),
+				LockMode:        conf.S3.LockMode,
+				LockDays:        conf.S3.LockDays,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create s3 sink: %v", err)
+			}
+
+			sinks = append(sinks, s3)
+
+		case config.SinkOTLP:
+			exporter, err := otlp.New(logger, otlp.Options{
+				Protocol: conf.OTLP.Protocol,
+				Endpoint: conf.OTLP.Endpoint,
+				Insecure: conf.OTLP.Insecure,
+				Gzip:     conf.OTLP.Gzip,
+				Headers:  secretHeaders(secrets, conf.OTLP.Headers),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create otlp sink: %v", err)
+			}
+
+			sinks = append(sinks, exporter)
+
+		case config.SinkWebhook:
+			webhook, err := webhook.New(logger, webhook.Options{
+				URL:             conf.Webhook.URL,
+				Method:          conf.Webhook.Method,
+				Mode:            conf.Webhook.Mode,
+				Template:        conf.Webhook.Template,
+				ContentType:     conf.Webhook.ContentType,
+				Headers:         secretHeaders(secrets, conf.Webhook.Headers),
+				HMACSecret:      optionalSecret(secrets, conf.Webhook.HMAC.Secret),
+				SignatureHeader: conf.Webhook.HMAC.Header,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("could not create webhook sink: %v", err)
+			}
+
+			sinks = append(sinks, webhook)
+
+		default:
+			return nil, fmt.Errorf("unknown sink '%s'", name)
+		}
+	}
+
+	multi := &sink.Multi{Sinks: sinks}
+	if deadLetters != nil {
+		multi.Fallback = spoolBatch(logger, deadLetters)
+	}
+
+	return multi, nil
+}
+
+// spoolBatch returns a fallback which spools the page a sink failed, so the replay only sends it to that sink.
+func spoolBatch(logger *logrus.Logger, deadLetters *spool.Spool) sink.Fallback {
+	return func(s sink.Sink, batch sink.Batch, err error) error {
+		path, spoolErr := deadLetters.Write(spool.Metadata{
+			Sink:    s.Name(),
+			Account: batch.Account,
+			Stream:  batch.Stream,
+			Error:   err.Error(),
+		}, batch.Logs)
+		if spoolErr != nil {
+			return spoolErr
+		}
+
+		logger.WithError(err).WithField("sink", s.Name()).WithField("path", path).WithField("total", len(batch.Logs)).
+			Warn("spooled logs which the sink failed to accept")
+
+		return nil
+	}
+}
+
+// secretHeaders resolves every header value as a secret reference, plain values are used as they are.
+func secretHeaders(secrets *secret.Resolver, headers map[string]string) map[string]secret.Func {
+	funcs := make(map[string]secret.Func, len(headers))
+	for name, value := range headers {
+		funcs[name] = secrets.Func(value)
+	}
+
+	return funcs
+}
+
+// logSender uploads logs through the Logs Ingestion API, as done by *msSentinel.Sentinel.
+type logSender interface {
+	SendLogs(ctx context.Context, l *logrus.Logger, endpoint, ruleID, streamName string, logs []record.Record) (*msSentinel.SendResult, error)
+}
+
+// sentinelSink uploads logs to Sentinel through the Logs Ingestion API and spools the batches that failed.
+type sentinelSink struct {
+	logger      *logrus.Logger
+	conf        *config.Config
+	sentinel    logSender
+	deadLetters *spool.Spool
+}
+
+func (s *sentinelSink) Name() string {
+	return config.SinkSentinel
+}
+
+func (s *sentinelSink) Close() error {
+	return nil
+}
+
+// Send uploads a batch, logs are considered delivered once they were either uploaded or spooled.
+func (s *sentinelSink) Send(ctx context.Context, batch sink.Batch) error {
+	streamName := s.dcrStreamName(batch.Stream)
+
+	result, err := s.sentinel.SendLogs(ctx, s.logger,
+		s.conf.Microsoft.DataCollection.Endpoint,
+		s.conf.Microsoft.DataCollection.RuleID,
+		streamName,
+		batch.Logs)
+	if err == nil {
+		return nil
+	}
+
+	if result == nil {
+		return fmt.Errorf("could not ship %s logs to sentinel: %v", batch.Stream, err)
+	}
+
+	if spoolErr := s.spoolFailures(streamName, result); spoolErr != nil {
+		s.logger.WithError(spoolErr).WithField("stream", batch.Stream).WithField("failed", len(result.Failed)).
+			WithField("batches", result.Batches).Error("page partially uploaded, not advancing checkpoint")
+		return fmt.Errorf("could not ship %s logs to sentinel: %v", batch.Stream, err)
+	}
+
+	return nil
+}
+
+// dcrStreamName returns the data collection rule stream that the logs of a 1Password stream are sent to.
+func (s *sentinelSink) dcrStreamName(stream string) string {
+	if s.conf.Microsoft.TableLayout != config.TableLayoutPerStream {
+		return s.conf.Microsoft.DataCollection.StreamName
+	}
+
+	switch stream {
+	case onepassword.StreamSignins:
+		return s.conf.Microsoft.DataCollection.SigninStreamName
+	case onepassword.StreamUsage:
+		return s.conf.Microsoft.DataCollection.UsageStreamName
+	default:
+		return s.conf.Microsoft.DataCollection.AuditStreamName
+	}
+}
+
+// spoolFailures writes the batches that failed to upload to the spool so they can be replayed later.
+// It returns an error when no spool is configured or when spooling failed, as the logs would otherwise be lost.
+func (s *sentinelSink) spoolFailures(streamName string, result *msSentinel.SendResult) error {
+	if s.deadLetters == nil {
+		return errors.New("no spool directory configured")
+	}
+
+	for _, failed := range result.Failed {
+		path, err := s.deadLetters.Write(spool.Metadata{
+			Endpoint:   s.conf.Microsoft.DataCollection.Endpoint,
+			RuleID:     s.conf.Microsoft.DataCollection.RuleID,
+			StreamName: streamName,
+			Error:      failed.Err.Error(),
+		}, failed.Logs)
+		if err != nil {
+			return err
+		}
+
+		s.logger.WithField("path", path).WithField("total", len(failed.Logs)).Warn("spooled logs which failed to upload")
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/hazcod/one2sen/config"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	msSentinel "github.com/hazcod/one2sen/pkg/sentinel"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/spool"
+	"github.com/sirupsen/logrus"
+	"testing"
+)
+
+type fakeSender struct {
+	streamName string
+	result     *msSentinel.SendResult
+	err        error
+}
+
+func (f *fakeSender) SendLogs(_ context.Context, _ *logrus.Logger, _, _, streamName string, _ []record.Record) (*msSentinel.SendResult, error) {
+	f.streamName = streamName
+	return f.result, f.err
+}
+
+// fakeSink accepts batches once err is cleared.
+type fakeSink struct {
+	err     error
+	batches []sink.Batch
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Send(_ context.Context, batch sink.Batch) error {
+	if s.err != nil {
+		return s.err
+	}
+
+	s.batches = append(s.batches, batch)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func newTestSentinelSink(t *testing.T, sender logSender, deadLetters *spool.Spool) *sentinelSink {
+	conf := &config.Config{}
+	conf.Microsoft.TableLayout = config.TableLayoutPerStream
+	conf.Microsoft.DataCollection.Endpoint = "https://dce"
+	conf.Microsoft.DataCollection.RuleID = "dcr-1"
+	conf.Microsoft.DataCollection.AuditStreamName = "Custom-Audit"
+
+	return &sentinelSink{logger: logrus.New(), conf: conf, sentinel: sender, deadLetters: deadLetters}
+}
+
+func TestSentinelSink_Send(t *testing.T) {
+	batch := sink.Batch{Stream: onepassword.StreamAudit, Logs: []record.Record{{"UUID": "a1"}, {"UUID": "a2"}}}
+	partial := &msSentinel.SendResult{Batches: 2, Succeeded: 1, Failed: []msSentinel.FailedBatch{{Logs: batch.Logs[1:], Err: errors.New("503")}}}
+
+	t.Run("partially uploaded", func(t *testing.T) {
+		deadLetters, err := spool.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sender := &fakeSender{result: partial, err: partial.Err()}
+
+		// the failed batch is spooled, so the page counts as delivered
+		if err := newTestSentinelSink(t, sender, deadLetters).Send(context.Background(), batch); err != nil {
+			t.Fatal(err)
+		}
+
+		if sender.streamName != "Custom-Audit" {
+			t.Fatalf("expected the audit stream of the dcr, got %s", sender.streamName)
+		}
+
+		paths, err := deadLetters.List()
+		if err != nil || len(paths) != 1 {
+			t.Fatalf("expected a spool file: %v", err)
+		}
+
+		meta, logs, err := deadLetters.Read(paths[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if meta.StreamName != "Custom-Audit" || meta.RuleID != "dcr-1" || len(logs) != 1 || logs[0]["UUID"] != "a2" {
+			t.Fatalf("unexpected spooled batch: %+v %v", meta, logs)
+		}
+	})
+
+	t.Run("no spool", func(t *testing.T) {
+		sender := &fakeSender{result: partial, err: partial.Err()}
+
+		if err := newTestSentinelSink(t, sender, nil).Send(context.Background(), batch); err == nil {
+			t.Fatal("expected an error without a spool to keep the failed logs")
+		}
+	})
+
+	t.Run("nothing uploaded", func(t *testing.T) {
+		deadLetters, err := spool.New(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sender := &fakeSender{err: errors.New("could not get token")}
+
+		if err := newTestSentinelSink(t, sender, deadLetters).Send(context.Background(), batch); err == nil {
+			t.Fatal("expected the error")
+		}
+
+		if paths, _ := deadLetters.List(); len(paths) != 0 {
+			t.Fatalf("expected nothing to be spooled, got %v", paths)
+		}
+	})
+}
+
+func TestSpoolBatch_Replay(t *testing.T) {
+	deadLetters, err := spool.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	failing := &fakeSink{err: errors.New("unavailable")}
+
+	multi := &sink.Multi{
+		Sinks:    []sink.Sink{newTestSentinelSink(t, &fakeSender{}, deadLetters), failing},
+		Fallback: spoolBatch(logger, deadLetters),
+	}
+
+	batch := sink.Batch{Account: "eu", Stream: onepassword.StreamAudit, Logs: []record.Record{{"UUID": "a1"}}}
+
+	// Sentinel accepted the page, so it is spooled for the failing sink only
+	if err := multi.Send(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	failing.err = nil
+
+	if err := replay(context.Background(), logger, nil, multi.Sinks, deadLetters); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failing.batches) != 1 || failing.batches[0].Account != "eu" || failing.batches[0].Stream != onepassword.StreamAudit {
+		t.Fatalf("expected the page to be replayed to the failed sink: %+v", failing.batches)
+	}
+
+	if paths, _ := deadLetters.List(); len(paths) != 0 {
+		t.Fatalf("expected the spool file to be removed, got %v", paths)
+	}
+}
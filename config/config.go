@@ -4,29 +4,92 @@ import (
 	"errors"
 	"fmt"
 	validator "github.com/asaskevich/govalidator"
+	"github.com/hazcod/one2sen/pkg/onepassword"
 	"github.com/kelseyhightower/envconfig"
 	"gopkg.in/yaml.v3"
 	"os"
+	"slices"
 	"strings"
 	"time"
 )
 
+const (
+	TableLayoutSingle    = "single"
+	TableLayoutPerStream = "per_stream"
+)
+
+const (
+	SinkSentinel      = "sentinel"
+	SinkSplunk        = "splunk"
+	SinkElasticsearch = "elasticsearch"
+	SinkSyslog        = "syslog"
+	SinkKafka         = "kafka"
+	SinkS3            = "s3"
+	SinkOTLP          = "otlp"
+	SinkWebhook       = "webhook"
+)
+
+const (
+	AuthClientSecret     = "client_secret"
+	AuthCertificate      = "certificate"
+	AuthManagedIdentity  = "managed_identity"
+	AuthWorkloadIdentity = "workload_identity"
+	AuthDefault          = "default"
+)
+
 const (
 	defaultLogLevel      = "DEBUG"
 	defaultRetentionDays = 90
-	defaultLookback      = "1d"
+	defaultLookback      = time.Hour * 24
+	defaultAccount       = "default"
 	defaultTenant        = "https://events.1password.com"
+	defaultConcurrency   = 3
+	defaultStream        = "Custom-OnePasswordLogs_CL"
+	defaultSigninStream  = "Custom-OnePasswordSignins_CL"
+	defaultUsageStream   = "Custom-OnePasswordItemUsages_CL"
+	defaultAuditStream   = "Custom-OnePasswordAuditEvents_CL"
+	defaultInterval      = time.Minute * 5
+	defaultJitter        = time.Second * 30
+	defaultEndpointName  = "one2sen-dce"
+	defaultRuleName      = "one2sen-dcr"
 )
 
+// the 1Password event streams which can be enabled per account
+var validStreams = []string{onepassword.StreamSignins, onepassword.StreamUsage, onepassword.StreamAudit}
+
+// Account is a single 1Password business account to collect events from.
+type Account struct {
+	// identifies the account in the Account column and the checkpoints
+	Name      string        `yaml:"name"`
+	ApiToken  string        `yaml:"api_token"`
+	EventsURL string        `yaml:"url"`
+	Lookback  time.Duration `yaml:"lookback"`
+
+	// signinattempts, itemusages and auditevents, defaults to all of them
+	Streams []string `yaml:"streams"`
+}
+
 type Config struct {
 	Log struct {
 		Level string `yaml:"level" env:"LOG_LEVEL"`
 	} `yaml:"log"`
 
+	// the outputs logs are sent to, defaults to sentinel
+	Sinks []string `yaml:"sinks" env:"SINKS"`
+
 	OnePassword struct {
 		ApiToken  string        `yaml:"api_token" env:"ONE_API_TOKEN"`
 		Lookback  time.Duration `yaml:"lookback" env:"ONE_LOOKBACK"`
 		EventsURL string        `yaml:"url" env:"ONE_URL"`
+
+		// how many streams are fetched at the same time
+		Concurrency int `yaml:"concurrency" env:"ONE_CONCURRENCY"`
+
+		// add the original event JSON in the RawEvent column
+		RawEvent bool `yaml:"raw_event" env:"ONE_RAW_EVENT"`
+
+		// when empty, a single account named default is created from api_token, url and lookback
+		Accounts []Account `yaml:"accounts"`
 	} `yaml:"onepassword"`
 
 	Microsoft struct {
@@ -35,18 +98,174 @@ type Config struct {
 		TenantID       string `yaml:"tenant_id" env:"MS_TENANT_ID" valid:"minstringlength(3)"`
 		SubscriptionID string `yaml:"subscription_id" env:"MS_SUB_ID" valid:"minstringlength(3)"`
 
+		// client_secret, certificate, managed_identity, workload_identity or default
+		Auth                string `yaml:"auth" env:"MS_AUTH"`
+		CertificatePath     string `yaml:"certificate_path" env:"MS_CERTIFICATE_PATH"`
+		CertificatePassword string `yaml:"certificate_password" env:"MS_CERTIFICATE_PASSWORD"`
+		FederatedTokenFile  string `yaml:"federated_token_file" env:"MS_FEDERATED_TOKEN_FILE"`
+
 		DataCollection struct {
 			Endpoint   string `yaml:"endpoint" env:"MS_DCR_ENDPOINT" valid:"minstringlength(3)"`
 			RuleID     string `yaml:"rule_id" env:"MS_DCR_RULE" valid:"minstringlength(3)"`
 			StreamName string `yaml:"stream_name" env:"MS_DCR_STREAM" valid:"minstringlength(3)"`
+
+			// the streams used by the per_stream table layout
+			SigninStreamName string `yaml:"signin_stream_name" env:"MS_DCR_SIGNIN_STREAM"`
+			UsageStreamName  string `yaml:"usage_stream_name" env:"MS_DCR_USAGE_STREAM"`
+			AuditStreamName  string `yaml:"audit_stream_name" env:"MS_DCR_AUDIT_STREAM"`
+
+			// the resources created by the provision command
+			EndpointName string `yaml:"endpoint_name" env:"MS_DCE_NAME"`
+			RuleName     string `yaml:"rule_name" env:"MS_DCR_NAME"`
+			Location     string `yaml:"location" env:"MS_DCR_LOCATION"`
 		} `yaml:"dcr"`
 
 		ResourceGroup string `yaml:"resource_group" env:"MS_RSG_ID" valid:"minstringlength(3)"`
 		WorkspaceName string `yaml:"workspace_name" env:"MS_WS_NAME" valid:"minstringlength(3)"`
 
+		// single puts all events in one table, per_stream uses a typed table per 1Password stream
+		TableLayout string `yaml:"table_layout" env:"MS_TABLE_LAYOUT"`
+
 		RetentionDays uint32 `yaml:"retention_days" env:"MS_RETENTION_DAYS"`
 		UpdateTable   bool   `yaml:"update_table" env:"MS_UPDATE_TABLE"`
+
+		// how many batches are uploaded at the same time
+		UploadWorkers int `yaml:"upload_workers" env:"MS_UPLOAD_WORKERS"`
 	} `yaml:"microsoft"`
+
+	Splunk struct {
+		URL        string        `yaml:"url" env:"SPLUNK_URL"`
+		Token      string        `yaml:"token" env:"SPLUNK_TOKEN"`
+		Index      string        `yaml:"index" env:"SPLUNK_INDEX"`
+		Source     string        `yaml:"source" env:"SPLUNK_SOURCE"`
+		SourceType string        `yaml:"sourcetype" env:"SPLUNK_SOURCETYPE"`
+		Host       string        `yaml:"host" env:"SPLUNK_HOST"`
+		Ack        bool          `yaml:"ack" env:"SPLUNK_ACK"`
+		AckTimeout time.Duration `yaml:"ack_timeout" env:"SPLUNK_ACK_TIMEOUT"`
+	} `yaml:"splunk"`
+
+	Elasticsearch struct {
+		URL      string `yaml:"url" env:"ES_URL"`
+		Username string `yaml:"username" env:"ES_USERNAME"`
+		Password string `yaml:"password" env:"ES_PASSWORD"`
+		APIKey   string `yaml:"api_key" env:"ES_API_KEY"`
+
+		// supports the {stream}, {account} and {date} placeholders
+		Index      string `yaml:"index" env:"ES_INDEX"`
+		DataStream bool   `yaml:"data_stream" env:"ES_DATA_STREAM"`
+	} `yaml:"elasticsearch"`
+
+	Syslog struct {
+		// udp, tcp or tls
+		Network string `yaml:"network" env:"SYSLOG_NETWORK"`
+		Address string `yaml:"address" env:"SYSLOG_ADDRESS"`
+		// cef or leef
+		Format string `yaml:"format" env:"SYSLOG_FORMAT"`
+		// octet_counting or non_transparent
+		Framing  string `yaml:"framing" env:"SYSLOG_FRAMING"`
+		Facility string `yaml:"facility" env:"SYSLOG_FACILITY"`
+		Hostname string `yaml:"hostname" env:"SYSLOG_HOSTNAME"`
+
+		TLS struct {
+			CAFile     string `yaml:"ca_file" env:"SYSLOG_TLS_CA_FILE"`
+			CertFile   string `yaml:"cert_file" env:"SYSLOG_TLS_CERT_FILE"`
+			KeyFile    string `yaml:"key_file" env:"SYSLOG_TLS_KEY_FILE"`
+			ServerName string `yaml:"server_name" env:"SYSLOG_TLS_SERVER_NAME"`
+		} `yaml:"tls"`
+	} `yaml:"syslog"`
+
+	Kafka struct {
+		Brokers []string `yaml:"brokers" env:"KAFKA_BROKERS"`
+		// supports the {stream} and {account} placeholders
+		Topic            string `yaml:"topic" env:"KAFKA_TOPIC"`
+		PartitionByActor bool   `yaml:"partition_by_actor" env:"KAFKA_PARTITION_BY_ACTOR"`
+
+		SASL struct {
+			// plain, scram-sha-256 or scram-sha-512
+			Mechanism string `yaml:"mechanism" env:"KAFKA_SASL_MECHANISM"`
+			Username  string `yaml:"username" env:"KAFKA_SASL_USERNAME"`
+			Password  string `yaml:"password" env:"KAFKA_SASL_PASSWORD"`
+		} `yaml:"sasl"`
+
+		TLS struct {
+			Enabled bool   `yaml:"enabled" env:"KAFKA_TLS"`
+			CAFile  string `yaml:"ca_file" env:"KAFKA_TLS_CA_FILE"`
+		} `yaml:"tls"`
+	} `yaml:"kafka"`
+
+	S3 struct {
+		Endpoint  string `yaml:"endpoint" env:"S3_ENDPOINT"`
+		Bucket    string `yaml:"bucket" env:"S3_BUCKET"`
+		Region    string `yaml:"region" env:"S3_REGION"`
+		Prefix    string `yaml:"prefix" env:"S3_PREFIX"`
+		PathStyle bool   `yaml:"path_style" env:"S3_PATH_STYLE"`
+
+		AccessKeyID     string `yaml:"access_key_id" env:"S3_ACCESS_KEY_ID"`
+		SecretAccessKey string `yaml:"secret_access_key" env:"S3_SECRET_ACCESS_KEY"`
+
+		// governance or compliance
+		LockMode string `yaml:"lock_mode" env:"S3_LOCK_MODE"`
+		LockDays int    `yaml:"lock_days" env:"S3_LOCK_DAYS"`
+	} `yaml:"s3"`
+
+	OTLP struct {
+		// grpc or http
+		Protocol string `yaml:"protocol" env:"OTLP_PROTOCOL"`
+		// host:port for grpc, a URL for http
+		Endpoint string `yaml:"endpoint" env:"OTLP_ENDPOINT"`
+		Insecure bool   `yaml:"insecure" env:"OTLP_INSECURE"`
+		Gzip     bool   `yaml:"gzip" env:"OTLP_GZIP"`
+		// values may be secret references
+		Headers map[string]string `yaml:"headers" env:"OTLP_HEADERS"`
+	} `yaml:"otlp"`
+
+	Webhook struct {
+		URL    string `yaml:"url" env:"WEBHOOK_URL"`
+		Method string `yaml:"method" env:"WEBHOOK_METHOD"`
+		// batch or event
+		Mode string `yaml:"mode" env:"WEBHOOK_MODE"`
+		// text/template for the request body
+		Template    string `yaml:"template" env:"WEBHOOK_TEMPLATE"`
+		ContentType string `yaml:"content_type" env:"WEBHOOK_CONTENT_TYPE"`
+		// values may be secret references
+		Headers map[string]string `yaml:"headers" env:"WEBHOOK_HEADERS"`
+
+		HMAC struct {
+			Secret string `yaml:"secret" env:"WEBHOOK_HMAC_SECRET"`
+			Header string `yaml:"header" env:"WEBHOOK_HMAC_HEADER"`
+		} `yaml:"hmac"`
+	} `yaml:"webhook"`
+
+	Daemon struct {
+		Interval time.Duration `yaml:"interval" env:"DAEMON_INTERVAL"`
+		Jitter   time.Duration `yaml:"jitter" env:"DAEMON_JITTER"`
+	} `yaml:"daemon"`
+
+	Checkpoint struct {
+		Path string `yaml:"path" env:"CHECKPOINT_PATH"`
+	} `yaml:"checkpoint"`
+
+	Spool struct {
+		Dir string `yaml:"dir" env:"SPOOL_DIR"`
+	} `yaml:"spool"`
+
+	Dedup struct {
+		// file that remembers which events were shipped, leave empty to disable deduplication
+		Path       string        `yaml:"path" env:"DEDUP_PATH"`
+		Window     time.Duration `yaml:"window" env:"DEDUP_WINDOW"`
+		MaxEntries int           `yaml:"max_entries" env:"DEDUP_MAX_ENTRIES"`
+	} `yaml:"dedup"`
+
+	// secret fields accept file://, env:// and op:// references besides plain values
+	Secrets struct {
+		Connect struct {
+			URL   string `yaml:"url" env:"OP_CONNECT_HOST"`
+			Token string `yaml:"token" env:"OP_CONNECT_TOKEN"`
+		} `yaml:"connect"`
+
+		// how long values fetched from 1Password Connect are cached
+		Refresh time.Duration `yaml:"refresh" env:"SECRETS_REFRESH"`
+	} `yaml:"secrets"`
 }
 
 func (c *Config) Validate() error {
@@ -54,20 +273,87 @@ func (c *Config) Validate() error {
 		c.Log.Level = defaultLogLevel
 	}
 
-	if c.OnePassword.Lookback == 0 {
-		c.OnePassword.Lookback, _ = time.ParseDuration(defaultLookback)
+	if c.Daemon.Interval == 0 {
+		c.Daemon.Interval = defaultInterval
+	}
+
+	if c.Daemon.Jitter == 0 {
+		c.Daemon.Jitter = defaultJitter
 	}
 
-	if c.OnePassword.ApiToken == "" {
-		return errors.New("no onepassword api token provided")
+	if c.OnePassword.Concurrency <= 0 {
+		c.OnePassword.Concurrency = defaultConcurrency
 	}
 
-	c.OnePassword.EventsURL = strings.TrimSuffix(c.OnePassword.EventsURL, "/")
-	if c.OnePassword.EventsURL == "" {
-		c.OnePassword.EventsURL = defaultTenant
+	if err := c.validateSinks(); err != nil {
+		return err
 	}
-	if !strings.HasPrefix(c.OnePassword.EventsURL, "https://") {
-		return errors.New("OnePassword tenant URL must start with https://")
+
+	switch c.Microsoft.TableLayout {
+	case "":
+		c.Microsoft.TableLayout = TableLayoutSingle
+	case TableLayoutSingle, TableLayoutPerStream:
+	default:
+		return fmt.Errorf("unknown table layout '%s', use %s or %s", c.Microsoft.TableLayout, TableLayoutSingle, TableLayoutPerStream)
+	}
+
+	switch c.Microsoft.Auth {
+	case "":
+		c.Microsoft.Auth = AuthClientSecret
+	case AuthClientSecret, AuthCertificate, AuthManagedIdentity, AuthWorkloadIdentity, AuthDefault:
+	default:
+		return fmt.Errorf("unknown azure authentication '%s', use %s, %s, %s, %s or %s", c.Microsoft.Auth,
+			AuthClientSecret, AuthCertificate, AuthManagedIdentity, AuthWorkloadIdentity, AuthDefault)
+	}
+
+	if c.Microsoft.DataCollection.StreamName == "" {
+		c.Microsoft.DataCollection.StreamName = defaultStream
+	}
+
+	if c.Microsoft.DataCollection.SigninStreamName == "" {
+		c.Microsoft.DataCollection.SigninStreamName = defaultSigninStream
+	}
+
+	if c.Microsoft.DataCollection.UsageStreamName == "" {
+		c.Microsoft.DataCollection.UsageStreamName = defaultUsageStream
+	}
+
+	if c.Microsoft.DataCollection.AuditStreamName == "" {
+		c.Microsoft.DataCollection.AuditStreamName = defaultAuditStream
+	}
+
+	if c.Microsoft.DataCollection.EndpointName == "" {
+		c.Microsoft.DataCollection.EndpointName = defaultEndpointName
+	}
+
+	if c.Microsoft.DataCollection.RuleName == "" {
+		c.Microsoft.DataCollection.RuleName = defaultRuleName
+	}
+
+	if len(c.OnePassword.Accounts) == 0 {
+		if c.OnePassword.ApiToken == "" {
+			return errors.New("no onepassword api token provided")
+		}
+
+		c.OnePassword.Accounts = []Account{{
+			Name:      defaultAccount,
+			ApiToken:  c.OnePassword.ApiToken,
+			EventsURL: c.OnePassword.EventsURL,
+			Lookback:  c.OnePassword.Lookback,
+		}}
+	}
+
+	names := make(map[string]bool, len(c.OnePassword.Accounts))
+	for i := range c.OnePassword.Accounts {
+		if err := c.OnePassword.Accounts[i].validate(); err != nil {
+			return err
+		}
+
+		name := c.OnePassword.Accounts[i].Name
+		if names[name] {
+			return fmt.Errorf("duplicate onepassword account '%s'", name)
+		}
+		names[name] = true
 	}
 
 	if valid, err := validator.ValidateStruct(c); !valid || err != nil {
@@ -77,6 +363,98 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+func (c *Config) validateSinks() error {
+	if len(c.Sinks) == 0 {
+		c.Sinks = []string{SinkSentinel}
+	}
+
+	for i, name := range c.Sinks {
+		if slices.Contains(c.Sinks[:i], name) {
+			return fmt.Errorf("sink '%s' is configured twice", name)
+		}
+
+		switch name {
+		case SinkSentinel:
+		case SinkSplunk:
+			if c.Splunk.URL == "" || c.Splunk.Token == "" {
+				return errors.New("the splunk sink needs a url and token")
+			}
+		case SinkElasticsearch:
+			if c.Elasticsearch.URL == "" {
+				return errors.New("the elasticsearch sink needs a url")
+			}
+		case SinkSyslog:
+			if c.Syslog.Address == "" {
+				return errors.New("the syslog sink needs an address")
+			}
+		case SinkKafka:
+			if len(c.Kafka.Brokers) == 0 {
+				return errors.New("the kafka sink needs at least one broker")
+			}
+		case SinkS3:
+			if c.S3.Endpoint == "" || c.S3.Bucket == "" {
+				return errors.New("the s3 sink needs an endpoint and bucket")
+			}
+		case SinkOTLP:
+			if c.OTLP.Endpoint == "" {
+				return errors.New("the otlp sink needs an endpoint")
+			}
+		case SinkWebhook:
+			if c.Webhook.URL == "" {
+				return errors.New("the webhook sink needs a url")
+			}
+		default:
+			return fmt.Errorf("unknown sink '%s', use %s, %s, %s, %s, %s, %s, %s or %s", name,
+				SinkSentinel, SinkSplunk, SinkElasticsearch, SinkSyslog, SinkKafka, SinkS3, SinkOTLP, SinkWebhook)
+		}
+	}
+
+	// a page which only some sinks accepted is spooled for the others, as sending it to all of them again duplicates it
+	if len(c.Sinks) > 1 && c.Spool.Dir == "" {
+		return errors.New("combining sinks needs a spool directory")
+	}
+
+	return nil
+}
+
+func (a *Account) validate() error {
+	if a.Name == "" {
+		return errors.New("onepassword account without a name")
+	}
+
+	if strings.Contains(a.Name, "/") {
+		return fmt.Errorf("onepassword account name '%s' cannot contain a /", a.Name)
+	}
+
+	if a.ApiToken == "" {
+		return fmt.Errorf("no api token provided for onepassword account '%s'", a.Name)
+	}
+
+	a.EventsURL = strings.TrimSuffix(a.EventsURL, "/")
+	if a.EventsURL == "" {
+		a.EventsURL = defaultTenant
+	}
+	if !strings.HasPrefix(a.EventsURL, "https://") {
+		return fmt.Errorf("OnePassword tenant URL of account '%s' must start with https://", a.Name)
+	}
+
+	if a.Lookback == 0 {
+		a.Lookback = defaultLookback
+	}
+
+	if len(a.Streams) == 0 {
+		a.Streams = slices.Clone(validStreams)
+	}
+
+	for _, stream := range a.Streams {
+		if !slices.Contains(validStreams, stream) {
+			return fmt.Errorf("unknown stream '%s' for onepassword account '%s', use %s", stream, a.Name, strings.Join(validStreams, ", "))
+		}
+	}
+
+	return nil
+}
+
 func (c *Config) Load(path string) error {
 	if path != "" {
 		configBytes, err := os.ReadFile(path)
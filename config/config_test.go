@@ -8,3 +8,44 @@ func TestConfig_Validate(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestConfig_ValidateAccounts(t *testing.T) {
+	conf := Config{}
+	conf.OnePassword.ApiToken = "token"
+
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(conf.OnePassword.Accounts) != 1 || conf.OnePassword.Accounts[0].Name != defaultAccount ||
+		conf.OnePassword.Accounts[0].Lookback != defaultLookback || len(conf.OnePassword.Accounts[0].Streams) != 3 {
+		t.Fatalf("unexpected default account: %+v", conf.OnePassword.Accounts)
+	}
+
+	conf = Config{}
+	conf.OnePassword.Accounts = []Account{
+		{Name: "eu", ApiToken: "token", EventsURL: "https://events.1password.eu"},
+		{Name: "eu", ApiToken: "token", Streams: []string{"auditevents"}},
+	}
+
+	if err := conf.Validate(); err == nil {
+		t.Fatal("expected an error for duplicate accounts")
+	}
+}
+
+func TestConfig_ValidateSinks(t *testing.T) {
+	conf := Config{}
+	conf.OnePassword.ApiToken = "token"
+	conf.Sinks = []string{SinkSentinel, SinkWebhook}
+	conf.Webhook.URL = "https://soar.example.com"
+
+	if err := conf.Validate(); err == nil {
+		t.Fatal("expected an error for combined sinks without a spool directory")
+	}
+
+	conf.Spool.Dir = "spool/"
+
+	if err := conf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// newCredentials returns static credentials with a secret key that is resolved on every request,
+// or the AWS credential chain when no access key was configured.
+func newCredentials(accessKeyID string, secretAccessKey secret.Func) *credentials.Credentials {
+	if accessKeyID == "" {
+		return credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		})
+	}
+
+	return credentials.New(&secretProvider{accessKeyID: accessKeyID, secretAccessKey: secretAccessKey})
+}
+
+// secretProvider always reports its credentials as expired, so minio retrieves them before every request.
+type secretProvider struct {
+	accessKeyID     string
+	secretAccessKey secret.Func
+}
+
+func (p *secretProvider) RetrieveWithCredContext(_ *credentials.CredContext) (credentials.Value, error) {
+	return p.Retrieve()
+}
+
+func (p *secretProvider) Retrieve() (credentials.Value, error) {
+	value := credentials.Value{
+		AccessKeyID: p.accessKeyID,
+		SignerType:  credentials.SignatureV4,
+	}
+
+	if p.secretAccessKey != nil {
+		secretAccessKey, err := p.secretAccessKey(context.Background())
+		if err != nil {
+			return credentials.Value{}, fmt.Errorf("could not get s3 secret access key: %v", err)
+		}
+
+		value.SecretAccessKey = secretAccessKey
+	}
+
+	return value, nil
+}
+
+func (p *secretProvider) IsExpired() bool {
+	return true
+}
@@ -0,0 +1,300 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/minio/minio-go/v7"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	LockModeGovernance = "governance"
+	LockModeCompliance = "compliance"
+
+	objectSuffix   = ".ndjson.gz"
+	manifestSuffix = ".manifest.json"
+)
+
+// Options configure the S3 archive sink.
+type Options struct {
+	// e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000 for MinIO
+	Endpoint string
+	Bucket   string
+	Region   string
+	// prepended to every object key
+	Prefix string
+	// address buckets as endpoint/bucket instead of bucket.endpoint, needed by most S3 compatible servers
+	PathStyle bool
+
+	// static credentials, when empty the AWS environment variables, credentials file or instance role are used
+	AccessKeyID     string
+	SecretAccessKey secret.Func
+
+	// lock objects for LockDays in governance or compliance mode, which needs object lock on the bucket
+	LockMode string
+	LockDays int
+
+	Retry sink.Retry
+}
+
+// S3 archives logs as gzip compressed NDJSON objects under account/stream/yyyy/mm/dd/hh/ in S3 compatible storage.
+// Every object is written in a single request, so it is either fully stored or not at all, and is followed by a
+// manifest which holds the record count and hashes of the object.
+type S3 struct {
+	logger *logrus.Logger
+	opts   Options
+	client *minio.Client
+}
+
+// Manifest describes an archived object, it is written next to the object once the object was stored.
+type Manifest struct {
+	Object  string `json:"object"`
+	Account string `json:"account"`
+	Stream  string `json:"stream"`
+	Records int    `json:"records"`
+
+	// size and hash of the uncompressed NDJSON
+	Bytes  int    `json:"bytes"`
+	SHA256 string `json:"sha256"`
+	// size and hash of the gzip compressed object as stored
+	CompressedBytes  int    `json:"compressed_bytes"`
+	CompressedSHA256 string `json:"compressed_sha256"`
+
+	FirstEvent string    `json:"first_event"`
+	LastEvent  string    `json:"last_event"`
+	Created    time.Time `json:"created"`
+}
+
+func New(logger *logrus.Logger, opts Options) (*S3, error) {
+	if opts.Endpoint == "" || opts.Bucket == "" {
+		return nil, errors.New("the s3 archive needs an endpoint and bucket")
+	}
+
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint '%s', use e.g. https://s3.amazonaws.com", opts.Endpoint)
+	}
+
+	if opts.LockMode != "" && opts.LockMode != LockModeGovernance && opts.LockMode != LockModeCompliance {
+		return nil, fmt.Errorf("unknown s3 lock mode '%s', use %s or %s", opts.LockMode, LockModeGovernance, LockModeCompliance)
+	}
+
+	if opts.LockMode != "" && opts.LockDays <= 0 {
+		return nil, errors.New("an s3 lock mode needs the number of days to lock objects for")
+	}
+
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+
+	bucketLookup := minio.BucketLookupAuto
+	if opts.PathStyle {
+		bucketLookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:        newCredentials(opts.AccessKeyID, opts.SecretAccessKey),
+		Secure:       endpoint.Scheme != "http",
+		Region:       opts.Region,
+		BucketLookup: bucketLookup,
+		// retries are done by putObject, with the same backoff as the other sinks
+		MaxRetries: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create s3 client: %v", err)
+	}
+
+	return &S3{
+		logger: logger,
+		opts:   opts,
+		client: client,
+	}, nil
+}
+
+func (s *S3) Name() string {
+	return "s3"
+}
+
+func (s *S3) Close() error {
+	return nil
+}
+
+// Send archives the logs of batch, split into one object for every hour the events happened in.
+// Object names are derived from their content, so sending the same logs again overwrites the object with itself.
+func (s *S3) Send(ctx context.Context, batch sink.Batch) error {
+	partitions, err := partition(batch.Logs)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range partitions {
+		if err := s.put(ctx, batch, part); err != nil {
+			return err
+		}
+	}
+
+	s.logger.WithField("module", "s3").WithField("stream", batch.Stream).WithField("objects", len(partitions)).
+		WithField("total", len(batch.Logs)).Debug("archived logs")
+
+	return nil
+}
+
+// hourPartition holds the encoded logs of events which happened in the same hour.
+type hourPartition struct {
+	hour       time.Time
+	ndjson     bytes.Buffer
+	records    int
+	firstEvent string
+	lastEvent  string
+}
+
+// partition encodes the logs as NDJSON grouped by the hour of the event, in order of time.
+func partition(logs []record.Record) ([]*hourPartition, error) {
+	byHour := map[time.Time]*hourPartition{}
+
+	for _, log := range logs {
+		timeGenerated, _ := log["TimeGenerated"].(string)
+
+		eventTime, err := onepassword.ParseTimeGenerated(timeGenerated)
+		if err != nil {
+			return nil, err
+		}
+
+		hour := eventTime.UTC().Truncate(time.Hour)
+
+		part, ok := byHour[hour]
+		if !ok {
+			part = &hourPartition{hour: hour, firstEvent: timeGenerated, lastEvent: timeGenerated}
+			byHour[hour] = part
+		}
+
+		line, err := json.Marshal(log)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode log: %v", err)
+		}
+
+		part.ndjson.Write(line)
+		part.ndjson.WriteByte('\n')
+		part.records++
+
+		// the timestamps sort lexically
+		part.firstEvent = min(part.firstEvent, timeGenerated)
+		part.lastEvent = max(part.lastEvent, timeGenerated)
+	}
+
+	partitions := make([]*hourPartition, 0, len(byHour))
+	for _, part := range byHour {
+		partitions = append(partitions, part)
+	}
+
+	slices.SortFunc(partitions, func(a, b *hourPartition) int {
+		return a.hour.Compare(b.hour)
+	})
+
+	return partitions, nil
+}
+
+// put uploads the object of a partition, followed by its manifest.
+func (s *S3) put(ctx context.Context, batch sink.Batch, part *hourPartition) error {
+	var compressed bytes.Buffer
+
+	// gzip without a modification time, so the same logs always compress to the same bytes
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(part.ndjson.Bytes()); err != nil {
+		return fmt.Errorf("could not compress logs: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("could not compress logs: %v", err)
+	}
+
+	sum := sha256.Sum256(part.ndjson.Bytes())
+	compressedSum := sha256.Sum256(compressed.Bytes())
+
+	manifest := Manifest{
+		Object:           s.objectKey(batch, part, hex.EncodeToString(sum[:])),
+		Account:          batch.Account,
+		Stream:           batch.Stream,
+		Records:          part.records,
+		Bytes:            part.ndjson.Len(),
+		SHA256:           hex.EncodeToString(sum[:]),
+		CompressedBytes:  compressed.Len(),
+		CompressedSHA256: hex.EncodeToString(compressedSum[:]),
+		FirstEvent:       part.firstEvent,
+		LastEvent:        part.lastEvent,
+		Created:          time.Now().UTC(),
+	}
+
+	if err := s.putObject(ctx, manifest.Object, compressed.Bytes(), "application/x-ndjson", "gzip", map[string]string{
+		"records": fmt.Sprintf("%d", manifest.Records),
+		"sha256":  manifest.SHA256,
+	}); err != nil {
+		return err
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode manifest: %v", err)
+	}
+
+	key := strings.TrimSuffix(manifest.Object, objectSuffix) + manifestSuffix
+
+	return s.putObject(ctx, key, encoded, "application/json", "", nil)
+}
+
+// putObject stores data in a single request with a Content-MD5 header, so a corrupted upload is rejected.
+func (s *S3) putObject(ctx context.Context, key string, data []byte, contentType, contentEncoding string, metadata map[string]string) error {
+	opts := minio.PutObjectOptions{
+		ContentType:      contentType,
+		ContentEncoding:  contentEncoding,
+		UserMetadata:     metadata,
+		SendContentMd5:   true,
+		DisableMultipart: true,
+	}
+
+	if s.opts.LockMode != "" {
+		opts.Mode = minio.Governance
+		if s.opts.LockMode == LockModeCompliance {
+			opts.Mode = minio.Compliance
+		}
+
+		opts.RetainUntilDate = time.Now().UTC().AddDate(0, 0, s.opts.LockDays)
+	}
+
+	return s.opts.Retry.Do(ctx, s.logger, "s3", func() error {
+		_, err := s.client.PutObject(ctx, s.opts.Bucket, key, bytes.NewReader(data), int64(len(data)), opts)
+		if err == nil {
+			return nil
+		}
+
+		// errors without a status code did not get a response, such as network errors
+		if resp := minio.ToErrorResponse(err); resp.StatusCode != 0 {
+			return fmt.Errorf("could not upload %s: %w", key, &sink.StatusError{StatusCode: resp.StatusCode, Message: resp.Message})
+		}
+
+		return fmt.Errorf("could not upload %s: %v", key, err)
+	})
+}
+
+// objectKey returns prefix/account/stream/yyyy/mm/dd/hh/<first event>-<hash>.ndjson.gz.
+func (s *S3) objectKey(batch sink.Batch, part *hourPartition, hash string) string {
+	first, _ := onepassword.ParseTimeGenerated(part.firstEvent)
+
+	name := first.UTC().Format("20060102T150405Z") + "-" + hash[:16] + objectSuffix
+
+	return s.opts.Prefix + path.Join(batch.Account, batch.Stream, part.hour.Format("2006/01/02/15"), name)
+}
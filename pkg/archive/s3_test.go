@@ -0,0 +1,214 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestS3_Send(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Content-MD5") == "" || !strings.Contains(r.Header.Get("Authorization"), "Credential=access/") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, err := decodeChunked(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		objects[r.URL.Path] = body
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	archive, err := New(logrus.New(), Options{
+		Endpoint:        server.URL,
+		Bucket:          "logs",
+		Region:          "us-east-1",
+		Prefix:          "one2sen",
+		PathStyle:       true,
+		AccessKeyID:     "access",
+		SecretAccessKey: secret.Static("secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audits, err := onepassword.ConvertAuditEventToFlatMap(nil, []onepassword.AuditEvent{
+		{UUID: "a1", Timestamp: "2024-01-02T03:04:05Z", Action: "create"},
+		{UUID: "a2", Timestamp: "2024-01-02T04:00:00Z", Action: "delete"},
+		{UUID: "a3", Timestamp: "2024-01-02T03:59:59Z", Action: "update"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Send(context.Background(), sink.Batch{Account: "eu", Stream: onepassword.StreamAudit, Logs: audits}); err != nil {
+		t.Fatal(err)
+	}
+
+	// two hours, each with an object and a manifest
+	if len(objects) != 4 {
+		t.Fatalf("expected 4 objects: %v", keys(objects))
+	}
+
+	for key, body := range objects {
+		if !strings.HasSuffix(key, manifestSuffix) {
+			continue
+		}
+
+		var manifest Manifest
+		if err := json.Unmarshal(body, &manifest); err != nil {
+			t.Fatal(err)
+		}
+
+		object, ok := objects["/logs/"+manifest.Object]
+		if !ok || !strings.HasPrefix(manifest.Object, "one2sen/eu/auditevents/2024/01/02/") {
+			t.Fatalf("manifest %s refers to unknown object %s", key, manifest.Object)
+		}
+
+		compressedSum := sha256.Sum256(object)
+		if hex.EncodeToString(compressedSum[:]) != manifest.CompressedSHA256 {
+			t.Fatalf("hash mismatch for %s", manifest.Object)
+		}
+
+		gz, err := gzip.NewReader(strings.NewReader(string(object)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := 0
+		for scanner := bufio.NewScanner(gz); scanner.Scan(); lines++ {
+		}
+
+		if lines != manifest.Records {
+			t.Fatalf("%s holds %d records, manifest says %d", manifest.Object, lines, manifest.Records)
+		}
+
+		if strings.Contains(manifest.Object, "/03/") && (manifest.Records != 2 || manifest.FirstEvent != "2024-01-02T03:04:05Z" || manifest.LastEvent != "2024-01-02T03:59:59Z") {
+			t.Fatalf("unexpected manifest: %+v", manifest)
+		}
+	}
+}
+
+func TestS3_SendRetry(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int
+	}{
+		// the object is uploaded again, followed by its manifest
+		{http.StatusServiceUnavailable, 3},
+		{http.StatusForbidden, 1},
+	}
+
+	audits, err := onepassword.ConvertAuditEventToFlatMap(nil, []onepassword.AuditEvent{
+		{UUID: "a1", Timestamp: "2024-01-02T03:04:05Z", Action: "create"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		var mu sync.Mutex
+		requests := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			requests++
+			if requests == 1 {
+				w.WriteHeader(tt.status)
+			}
+		}))
+
+		archive, err := New(logrus.New(), Options{
+			Endpoint:        server.URL,
+			Bucket:          "logs",
+			Region:          "us-east-1",
+			PathStyle:       true,
+			AccessKeyID:     "access",
+			SecretAccessKey: secret.Static("secret"),
+			Retry:           sink.Retry{BaseDelay: time.Millisecond},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = archive.Send(context.Background(), sink.Batch{Account: "eu", Stream: onepassword.StreamAudit, Logs: audits})
+		if (tt.requests == 1) != (err != nil) {
+			t.Errorf("%d: unexpected error: %v", tt.status, err)
+		}
+
+		if requests != tt.requests {
+			t.Errorf("%d: expected %d requests, got %d", tt.status, tt.requests, requests)
+		}
+
+		server.Close()
+	}
+}
+
+// decodeChunked reads a body sent with the streaming signature, which S3 uses over plain http.
+func decodeChunked(r *http.Request) ([]byte, error) {
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return io.ReadAll(r.Body)
+	}
+
+	var body []byte
+	reader := bufio.NewReader(r.Body)
+
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.ParseInt(strings.SplitN(header, ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+
+		if size == 0 {
+			return body, nil
+		}
+
+		body = append(body, chunk[:size]...)
+	}
+}
+
+func keys(objects map[string][]byte) []string {
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	return names
+}
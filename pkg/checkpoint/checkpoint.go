@@ -0,0 +1,96 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"os"
+	"sync"
+	"time"
+)
+
+// Checkpoint records how far a single 1Password event stream has been shipped.
+type Checkpoint struct {
+	Cursor        string    `json:"cursor"`
+	LastEventTime time.Time `json:"last_event_time"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Store keeps a checkpoint per stream and persists them to a JSON file.
+// A store without a path only keeps checkpoints in memory.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	streams map[string]Checkpoint
+}
+
+func New(path string) (*Store, error) {
+	store := Store{
+		path:    path,
+		streams: make(map[string]Checkpoint),
+	}
+
+	if path == "" {
+		return &store, nil
+	}
+
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read checkpoint file '%s': %v", path, err)
+	}
+
+	if len(contents) == 0 {
+		return &store, nil
+	}
+
+	if err := json.Unmarshal(contents, &store.streams); err != nil {
+		return nil, fmt.Errorf("could not decode checkpoint file '%s': %v", path, err)
+	}
+
+	return &store, nil
+}
+
+// Get returns the checkpoint for stream and whether one was recorded.
+func (s *Store) Get(stream string) (Checkpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp, ok := s.streams[stream]
+	return cp, ok
+}
+
+// Set records the checkpoint for stream and persists the store.
+func (s *Store) Set(stream string, cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cp.UpdatedAt.IsZero() {
+		cp.UpdatedAt = time.Now().UTC()
+	}
+
+	s.streams[stream] = cp
+
+	return s.save()
+}
+
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	contents, err := json.MarshalIndent(s.streams, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode checkpoints: %v", err)
+	}
+
+	if err := utils.WriteFileAtomic(s.path, contents); err != nil {
+		return fmt.Errorf("could not save checkpoints: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package checkpoint
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	store, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.Get("signinattempts"); ok {
+		t.Fatal("expected no checkpoint on first run")
+	}
+
+	lastEvent := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := store.Set("signinattempts", Checkpoint{Cursor: "abc", LastEventTime: lastEvent}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp, ok := reloaded.Get("signinattempts")
+	if !ok || cp.Cursor != "abc" || !cp.LastEventTime.Equal(lastEvent) {
+		t.Fatalf("unexpected checkpoint: %+v", cp)
+	}
+}
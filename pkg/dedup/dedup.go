@@ -0,0 +1,256 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWindow     = time.Hour * 24 * 7
+	defaultMaxEntries = 500_000
+
+	// expired entries are dropped from the file at most this often
+	compactInterval = time.Hour
+)
+
+// Set remembers which events have already been shipped, keyed on their id.
+// Entries are kept for a time window after their event time and the set never grows much beyond a maximum size,
+// evicting the oldest events first. A set without a path only keeps its entries in memory.
+//
+// The file is an append log with an entry per line, so marking a page of events as shipped only appends its ids.
+// It is rewritten without the expired and evicted entries every compactInterval or once the set grew 10% too large.
+type Set struct {
+	path       string
+	window     time.Duration
+	maxEntries int
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+	// the number of entries in the file, which includes ids that were written more than once
+	lines       int
+	compactedAt time.Time
+}
+
+type entry struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+func New(path string, window time.Duration, maxEntries int) (*Set, error) {
+	if window <= 0 {
+		window = defaultWindow
+	}
+
+	if maxEntries <= 0 {
+		maxEntries = defaultMaxEntries
+	}
+
+	set := Set{
+		path:        path,
+		window:      window,
+		maxEntries:  maxEntries,
+		seen:        make(map[string]time.Time),
+		compactedAt: time.Now(),
+	}
+
+	if path == "" {
+		return &set, nil
+	}
+
+	if err := set.load(); err != nil {
+		return nil, err
+	}
+
+	set.prune(time.Now())
+
+	// start from a file without the entries which were dropped while loading
+	if set.lines != len(set.seen) {
+		if err := set.compact(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &set, nil
+}
+
+// Seen returns whether the event with id has already been shipped.
+func (s *Set) Seen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.seen[id]
+	return ok
+}
+
+// Add marks events as shipped and appends them to the file. ids and eventTimes have to be of the same length.
+func (s *Set) Add(ids []string, eventTimes []time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines bytes.Buffer
+	encoder := json.NewEncoder(&lines)
+	added := 0
+
+	for i, id := range ids {
+		if id == "" {
+			continue
+		}
+
+		s.seen[id] = eventTimes[i]
+
+		if err := encoder.Encode(entry{ID: id, Time: eventTimes[i]}); err != nil {
+			return fmt.Errorf("could not encode dedup entry: %v", err)
+		}
+
+		added++
+	}
+
+	now := time.Now()
+
+	// pruning walks the whole set, so only do so once it is worth rewriting the file for
+	if len(s.seen) > s.maxEntries+s.maxEntries/10 || now.Sub(s.compactedAt) > compactInterval {
+		s.prune(now)
+		s.compactedAt = now
+
+		return s.compact()
+	}
+
+	return s.append(lines.Bytes(), added)
+}
+
+// Len returns how many events are remembered.
+func (s *Set) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.seen)
+}
+
+// prune drops entries outside of the window and the oldest entries over the maximum size.
+func (s *Set) prune(now time.Time) {
+	cutoff := now.Add(-s.window)
+
+	for id, eventTime := range s.seen {
+		if eventTime.Before(cutoff) {
+			delete(s.seen, id)
+		}
+	}
+
+	if len(s.seen) <= s.maxEntries {
+		return
+	}
+
+	ids := make([]string, 0, len(s.seen))
+	for id := range s.seen {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return s.seen[ids[i]].Before(s.seen[ids[j]])
+	})
+
+	for _, id := range ids[:len(ids)-s.maxEntries] {
+		delete(s.seen, id)
+	}
+}
+
+// load reads the entries of the file, a later entry of the same id replaces an earlier one.
+func (s *Set) load() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read dedup file '%s': %v", s.path, err)
+	}
+	defer file.Close()
+
+	var broken error
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// only the last line can be incomplete, when the process stopped while appending to it
+		if broken != nil {
+			return broken
+		}
+
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			broken = fmt.Errorf("could not decode dedup file '%s': %v", s.path, err)
+			continue
+		}
+
+		s.lines++
+
+		if e.ID != "" {
+			s.seen[e.ID] = e.Time
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read dedup file '%s': %v", s.path, err)
+	}
+
+	if broken != nil {
+		// make sure the incomplete line is dropped from the file
+		s.lines++
+	}
+
+	return nil
+}
+
+func (s *Set) append(lines []byte, total int) error {
+	if s.path == "" || total == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open dedup file '%s': %v", s.path, err)
+	}
+
+	if _, err := file.Write(lines); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("could not write dedup entries: %v", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not close dedup file: %v", err)
+	}
+
+	s.lines += total
+
+	return nil
+}
+
+// compact replaces the file with the entries of the set.
+func (s *Set) compact() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var contents bytes.Buffer
+	encoder := json.NewEncoder(&contents)
+
+	for id, eventTime := range s.seen {
+		if err := encoder.Encode(entry{ID: id, Time: eventTime}); err != nil {
+			return fmt.Errorf("could not encode dedup entry: %v", err)
+		}
+	}
+
+	if err := utils.WriteFileAtomic(s.path, contents.Bytes()); err != nil {
+		return fmt.Errorf("could not save dedup set: %v", err)
+	}
+
+	s.lines = len(s.seen)
+
+	return nil
+}
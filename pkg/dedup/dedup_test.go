@@ -0,0 +1,85 @@
+package dedup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSet_Persist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.jsonl")
+
+	set, err := New(path, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	if err := set.Add([]string{"old", "a", "b", "c"}, []time.Time{now.Add(-time.Hour * 2), now.Add(-3 * time.Minute), now.Add(-2 * time.Minute), now.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := New(path, time.Hour, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// old is outside of the window and a is evicted as the oldest entry over the maximum
+	for id, expected := range map[string]bool{"old": false, "a": false, "b": true, "c": true} {
+		if reloaded.Seen(id) != expected {
+			t.Fatalf("expected seen(%s) to be %v", id, expected)
+		}
+	}
+}
+
+func TestSet_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.jsonl")
+
+	set, err := New(path, time.Hour, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := set.Add([]string{id}, []time.Time{now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(contents), "\n"); lines != 3 {
+		t.Fatalf("expected every page to append its entries, got %d lines", lines)
+	}
+
+	// a crash while appending leaves an incomplete last line behind
+	if err := os.WriteFile(path, append(contents, []byte(`{"id":"d","ti`)...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := New(path, time.Hour, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.Len() != 3 || !reloaded.Seen("c") || reloaded.Seen("d") {
+		t.Fatalf("expected the complete entries to be loaded, got %d", reloaded.Len())
+	}
+
+	// the incomplete line is dropped by rewriting the file, so later entries are appended to a clean line
+	if err := reloaded.Add([]string{"e"}, []time.Time{now}); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded, err = New(path, time.Hour, 100); err != nil || !reloaded.Seen("e") {
+		t.Fatalf("expected e to be remembered: %v", err)
+	}
+}
@@ -0,0 +1,419 @@
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIndex           = "onepassword-{stream}-{date}"
+	defaultDataStreamIndex = "logs-1password.{stream}-default"
+
+	defaultMaxBatchBytes = 5 * 1000 * 1000
+
+	// the date format used for daily indices
+	indexDateFormat = "2006.01.02"
+
+	// the index template keeping the original log out of the mapping, above the priority 100 of the built-in logs template
+	templateName     = "one2sen-onepassword"
+	templatePriority = 200
+)
+
+var placeholderPattern = regexp.MustCompile(`\{[a-z]+\}`)
+
+// Options configure the Elasticsearch or OpenSearch sink.
+type Options struct {
+	URL string
+
+	// either basic authentication or an API key, both optional
+	Username string
+	Password secret.Func
+	APIKey   secret.Func
+
+	// index name with {stream}, {account} and {date} placeholders
+	Index string
+	// write to data streams, which only accept create operations
+	DataStream bool
+
+	MaxBatchBytes int
+	Retry         sink.Retry
+}
+
+// Bulk writes logs to Elasticsearch or OpenSearch through the _bulk API, mapped onto the Elastic Common Schema.
+// The 1Password event UUID is used as document id, so sending the same event twice never duplicates it.
+// Documents which the cluster rejects, such as for a mapping conflict, are not retried but returned in a sink.RejectedError.
+type Bulk struct {
+	logger     *logrus.Logger
+	opts       Options
+	httpClient *http.Client
+
+	templateLock      sync.Mutex
+	templateInstalled bool
+}
+
+type bulkAction struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id,omitempty"`
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func New(logger *logrus.Logger, opts Options) (*Bulk, error) {
+	if opts.URL == "" {
+		return nil, errors.New("no elasticsearch url provided")
+	}
+
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+
+	if opts.Index == "" {
+		opts.Index = defaultIndex
+		if opts.DataStream {
+			opts.Index = defaultDataStreamIndex
+		}
+	}
+
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = defaultMaxBatchBytes
+	}
+
+	return &Bulk{
+		logger:     logger,
+		opts:       opts,
+		httpClient: utils.NewLogHttpClient(logger),
+	}, nil
+}
+
+func (b *Bulk) Name() string {
+	return "elasticsearch"
+}
+
+func (b *Bulk) Close() error {
+	return nil
+}
+
+// bulkDoc is a single document with its encoded action and source lines.
+type bulkDoc struct {
+	log   record.Record
+	lines []byte
+	// why the cluster rejected the document
+	reason error
+}
+
+// Send indexes every log of batch, retrying the documents which were rejected because the cluster was busy.
+// When the cluster refused some documents, the others are indexed and the refused logs are returned in a sink.RejectedError.
+func (b *Bulk) Send(ctx context.Context, batch sink.Batch) error {
+	docs := make([]bulkDoc, 0, len(batch.Logs))
+
+	for _, log := range batch.Logs {
+		doc, err := toECS(batch.Stream, log)
+		if err != nil {
+			return err
+		}
+
+		lines, err := b.encode(batch, doc)
+		if err != nil {
+			return err
+		}
+
+		docs = append(docs, bulkDoc{log: log, lines: lines})
+	}
+
+	b.ensureTemplate(ctx)
+
+	var rejected []bulkDoc
+
+	for _, chunk := range sink.Chunk(docs, b.opts.MaxBatchBytes, func(doc bulkDoc) int { return len(doc.lines) }) {
+		chunkRejected, err := b.sendWithRetry(ctx, chunk)
+		if err != nil {
+			return err
+		}
+
+		rejected = append(rejected, chunkRejected...)
+	}
+
+	b.logger.WithField("module", "elasticsearch").WithField("stream", batch.Stream).
+		WithField("total", len(batch.Logs)).WithField("rejected", len(rejected)).Debug("indexed logs")
+
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	logs := make([]record.Record, 0, len(rejected))
+	errs := make([]error, 0, len(rejected))
+	for _, doc := range rejected {
+		logs = append(logs, doc.log)
+		errs = append(errs, doc.reason)
+	}
+
+	return &sink.RejectedError{
+		Rejected: sink.Batch{Account: batch.Account, Stream: batch.Stream, Logs: logs},
+		Err:      fmt.Errorf("elasticsearch rejected %d documents: %w", len(rejected), errors.Join(errs...)),
+	}
+}
+
+func (b *Bulk) encode(batch sink.Batch, doc map[string]any) ([]byte, error) {
+	action := bulkAction{Index: b.indexName(batch, doc)}
+
+	if event, ok := doc["event"].(map[string]any); ok {
+		action.ID, _ = event["id"].(string)
+	}
+
+	op := "index"
+	if b.opts.DataStream {
+		op = "create"
+	}
+
+	actionLine, err := json.Marshal(map[string]bulkAction{op: action})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode bulk action: %v", err)
+	}
+
+	docLine, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode document: %v", err)
+	}
+
+	lines := make([]byte, 0, len(actionLine)+len(docLine)+2)
+	lines = append(lines, actionLine...)
+	lines = append(lines, '\n')
+	lines = append(lines, docLine...)
+	lines = append(lines, '\n')
+
+	return lines, nil
+}
+
+// indexName fills in the placeholders of the index pattern, {date} is the day of the event.
+func (b *Bulk) indexName(batch sink.Batch, doc map[string]any) string {
+	date := time.Now().UTC()
+	if timestamp, ok := doc["@timestamp"].(string); ok {
+		if parsed, err := onepassword.ParseTimeGenerated(timestamp); err == nil {
+			date = parsed.UTC()
+		}
+	}
+
+	return strings.NewReplacer(
+		"{stream}", batch.Stream,
+		"{account}", batch.Account,
+		"{date}", date.Format(indexDateFormat),
+	).Replace(b.opts.Index)
+}
+
+// sendWithRetry sends docs and sends the ones which failed on a busy cluster again.
+// It returns the documents which were rejected, as sending them again would fail the same way.
+func (b *Bulk) sendWithRetry(ctx context.Context, docs []bulkDoc) ([]bulkDoc, error) {
+	var rejected []bulkDoc
+
+	err := b.opts.Retry.Do(ctx, b.logger, "elasticsearch", func() error {
+		retry, docsRejected, err := b.send(ctx, docs)
+		if err != nil {
+			return err
+		}
+
+		rejected = append(rejected, docsRejected...)
+
+		if len(retry) > 0 {
+			docs = retry
+			return fmt.Errorf("%d documents failed because the cluster is busy", len(retry))
+		}
+
+		return nil
+	})
+
+	return rejected, err
+}
+
+// send performs a single bulk request and returns the documents which should be retried
+// and the documents which were rejected, with the reason set.
+func (b *Bulk) send(ctx context.Context, docs []bulkDoc) ([]bulkDoc, []bulkDoc, error) {
+	var payload bytes.Buffer
+	for _, doc := range docs {
+		payload.Write(doc.lines)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.opts.URL+"/_bulk", &payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create bulk request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	if err := b.authenticate(ctx, req); err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not reach elasticsearch: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read bulk response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("bulk request failed: %w", sink.NewStatusError(resp, body))
+	}
+
+	var bulkResp bulkResponse
+	if err := json.Unmarshal(body, &bulkResp); err != nil {
+		return nil, nil, fmt.Errorf("could not decode bulk response: %v", err)
+	}
+
+	if !bulkResp.Errors {
+		return nil, nil, nil
+	}
+
+	if len(bulkResp.Items) != len(docs) {
+		return nil, nil, fmt.Errorf("bulk response holds %d items for %d documents", len(bulkResp.Items), len(docs))
+	}
+
+	var retry, rejected []bulkDoc
+
+	for i, item := range bulkResp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+			case result.Status == http.StatusConflict:
+				// the document was created before, which is what we want
+			case result.Status == http.StatusTooManyRequests || result.Status >= http.StatusInternalServerError:
+				retry = append(retry, docs[i])
+			case result.Error != nil:
+				docs[i].reason = fmt.Errorf("%s: %s", result.Error.Type, result.Error.Reason)
+				rejected = append(rejected, docs[i])
+			default:
+				docs[i].reason = fmt.Errorf("document rejected with status %d", result.Status)
+				rejected = append(rejected, docs[i])
+			}
+		}
+	}
+
+	return retry, rejected, nil
+}
+
+func (b *Bulk) authenticate(ctx context.Context, req *http.Request) error {
+	if b.opts.APIKey != nil {
+		apiKey, err := b.opts.APIKey(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get elasticsearch api key: %v", err)
+		}
+
+		req.Header.Set("Authorization", "ApiKey "+apiKey)
+		return nil
+	}
+
+	if b.opts.Username != "" {
+		password := ""
+		if b.opts.Password != nil {
+			var err error
+			if password, err = b.opts.Password(ctx); err != nil {
+				return fmt.Errorf("could not get elasticsearch password: %v", err)
+			}
+		}
+
+		req.SetBasicAuth(b.opts.Username, password)
+	}
+
+	return nil
+}
+
+// ensureTemplate installs the index template before the first documents are sent.
+// It maps the original log under onepassword without indexing it, as its fields change their type between events.
+// Failing to install it only disables the protection against such mapping conflicts, so it does not fail the batch.
+func (b *Bulk) ensureTemplate(ctx context.Context) {
+	b.templateLock.Lock()
+	defer b.templateLock.Unlock()
+
+	if b.templateInstalled {
+		return
+	}
+
+	err := b.putTemplate(ctx)
+	if err == nil {
+		b.templateInstalled = true
+		return
+	}
+
+	// do not try again when the cluster will refuse it again, for example for lack of privileges
+	var statusErr *sink.StatusError
+	if errors.As(err, &statusErr) && !statusErr.Retryable() {
+		b.templateInstalled = true
+	}
+
+	b.logger.WithError(err).WithField("module", "elasticsearch").WithField("template", templateName).
+		Warn("could not install index template, documents with conflicting onepassword fields will be rejected")
+}
+
+func (b *Bulk) putTemplate(ctx context.Context) error {
+	template := map[string]any{
+		// every placeholder of the index name can be anything
+		"index_patterns": []string{placeholderPattern.ReplaceAllString(b.opts.Index, "*")},
+		"priority":       templatePriority,
+		"template": map[string]any{
+			"mappings": map[string]any{
+				"properties": map[string]any{
+					"onepassword": map[string]any{"type": "object", "enabled": false},
+				},
+			},
+		},
+	}
+
+	if b.opts.DataStream {
+		template["data_stream"] = map[string]any{}
+	}
+
+	payload, err := json.Marshal(template)
+	if err != nil {
+		return fmt.Errorf("could not encode index template: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.opts.URL+"/_index_template/"+templateName, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("could not create index template request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := b.authenticate(ctx, req); err != nil {
+		return err
+	}
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach elasticsearch: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("index template request failed: %w", sink.NewStatusError(resp, body))
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package elastic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulk_Send(t *testing.T) {
+	var actions []map[string]bulkAction
+	var docs []map[string]any
+
+	var template map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "ApiKey key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodPut && r.URL.Path == "/_index_template/"+templateName {
+			if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			return
+		}
+
+		if r.URL.Path != "/_bulk" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]bulkAction
+			if err := json.Unmarshal(scanner.Bytes(), &action); err != nil || !scanner.Scan() {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			var doc map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			actions = append(actions, action)
+			docs = append(docs, doc)
+		}
+
+		// the second document already exists in the data stream
+		_, _ = w.Write([]byte(`{"errors":true,"items":[{"create":{"status":201}},{"create":{"status":409}}]}`))
+	}))
+	defer server.Close()
+
+	bulk, err := New(logrus.New(), Options{URL: server.URL, APIKey: secret.Static("key"), DataStream: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signins, err := onepassword.ConvertSigninToFlatMap(nil, []onepassword.Event{
+		{UUID: "e1", Timestamp: "2024-01-02T03:04:05.123Z", Type: "credentials_ok", Client: onepassword.Client{IPAddress: "192.0.2.1"}},
+		{UUID: "e2", Timestamp: "2024-01-02T03:04:06.123Z", Type: "mfa_failed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signins[0]["ActorEmail"] = "jane@example.com"
+
+	if err := bulk.Send(context.Background(), sink.Batch{Account: "default", Stream: onepassword.StreamSignins, Logs: signins}); err != nil {
+		t.Fatal(err)
+	}
+
+	if template == nil || template["index_patterns"].([]any)[0] != "logs-1password.*-default" || template["data_stream"] == nil {
+		t.Fatalf("unexpected index template: %v", template)
+	}
+
+	if len(actions) != 2 || actions[0]["create"].ID != "e1" || actions[0]["create"].Index != "logs-1password.signinattempts-default" {
+		t.Fatalf("unexpected bulk actions: %+v", actions)
+	}
+
+	event := docs[0]["event"].(map[string]any)
+	source := docs[0]["source"].(map[string]any)
+	user := docs[0]["user"].(map[string]any)
+
+	if event["action"] != "credentials_ok" || event["outcome"] != "success" || source["ip"] != "192.0.2.1" || user["email"] != "jane@example.com" {
+		t.Fatalf("unexpected ECS document: %v", docs[0])
+	}
+
+	if docs[1]["event"].(map[string]any)["outcome"] != "failure" {
+		t.Fatalf("expected a failed outcome: %v", docs[1])
+	}
+}
+
+func TestBulk_Send_Rejected(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			return
+		}
+
+		requests++
+
+		// the second document can never be indexed, the third only on a second attempt
+		switch requests {
+		case 1:
+			_, _ = w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},` +
+				`{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field"}}},` +
+				`{"index":{"status":503,"error":{"type":"unavailable_shards_exception","reason":"primary shard is not active"}}}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+		}
+	}))
+	defer server.Close()
+
+	bulk, err := New(logrus.New(), Options{URL: server.URL, Retry: sink.Retry{BaseDelay: time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signins, err := onepassword.ConvertSigninToFlatMap(nil, []onepassword.Event{
+		{UUID: "e1", Timestamp: "2024-01-02T03:04:05.123Z"},
+		{UUID: "e2", Timestamp: "2024-01-02T03:04:06.123Z"},
+		{UUID: "e3", Timestamp: "2024-01-02T03:04:07.123Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the document which can never be indexed is handed back, so it is not lost
+	err = bulk.Send(context.Background(), sink.Batch{Account: "default", Stream: onepassword.StreamSignins, Logs: signins})
+
+	var rejected *sink.RejectedError
+	if !errors.As(err, &rejected) || len(rejected.Rejected.Logs) != 1 || rejected.Rejected.Logs[0]["UUID"] != "e2" {
+		t.Fatalf("expected the second document to be rejected: %v", err)
+	}
+
+	if rejected.Rejected.Account != "default" || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Fatalf("unexpected rejection: %+v", rejected)
+	}
+
+	if requests != 2 {
+		t.Fatalf("expected only the unavailable document to be sent again, got %d requests", requests)
+	}
+}
@@ -0,0 +1,89 @@
+package elastic
+
+import (
+	"github.com/hazcod/one2sen/pkg/record"
+)
+
+// ECS field values for the event categorisation.
+var streamCategories = map[string]string{
+	"signinattempts": "authentication",
+	"itemusages":     "iam",
+	"auditevents":    "configuration",
+}
+
+// toECS maps a converted 1Password log onto the Elastic Common Schema.
+// Both the single and the per_stream table layout are understood, the original log is kept under onepassword.
+func toECS(stream string, log record.Record) (map[string]any, error) {
+	// normalise nested structs into plain maps so both layouts can be read the same way
+	original, err := log.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := map[string]any{
+		"onepassword": original,
+	}
+
+	event := map[string]any{
+		"kind":    "event",
+		"module":  "1password",
+		"dataset": "1password." + stream,
+	}
+
+	if category, ok := streamCategories[stream]; ok {
+		event["category"] = []string{category}
+	}
+
+	setString(doc, "@timestamp", record.Lookup(original, "TimeGenerated"))
+	setString(event, "id", record.Lookup(original, "UUID", "Data.UUID"))
+	setString(event, "action", record.Lookup(original, "Action", "Data.Action", "EventType", "Data.EventType"))
+
+	if ok, isBool := record.Lookup(original, "OK", "Data.OK").(bool); isBool {
+		event["outcome"] = "failure"
+		if ok {
+			event["outcome"] = "success"
+		}
+	}
+
+	doc["event"] = event
+
+	user := map[string]any{}
+	setString(user, "id", record.Lookup(original, "ActorUUID", "Data.ActorUUID"))
+	setString(user, "name", record.Lookup(original, "ActorName", "Data.ActorName"))
+	setString(user, "email", record.Lookup(original, "ActorEmail", "Data.ActorEmail"))
+	if len(user) > 0 {
+		doc["user"] = user
+	}
+
+	geo := map[string]any{}
+	setString(geo, "country_iso_code", record.Lookup(original, "Country", "Location.country"))
+	setString(geo, "region_name", record.Lookup(original, "Region", "Location.region"))
+	setString(geo, "city_name", record.Lookup(original, "City", "Location.city"))
+
+	lat, latOK := record.Lookup(original, "Latitude", "Location.latitude").(float64)
+	lon, lonOK := record.Lookup(original, "Longitude", "Location.longitude").(float64)
+	if latOK && lonOK && (lat != 0 || lon != 0) {
+		geo["location"] = map[string]any{"lat": lat, "lon": lon}
+	}
+
+	source := map[string]any{}
+	setString(source, "ip", record.Lookup(original, "IPAddress", "Client.ip_address", "SessionIP", "Data.SessionIP"))
+	if len(geo) > 0 {
+		source["geo"] = geo
+	}
+	if len(source) > 0 {
+		doc["source"] = source
+	}
+
+	if account, ok := original["Account"].(string); ok && account != "" {
+		doc["organization"] = map[string]any{"name": account}
+	}
+
+	return doc, nil
+}
+
+func setString(target map[string]any, key string, value any) {
+	if s, ok := value.(string); ok && s != "" {
+		target[key] = s
+	}
+}
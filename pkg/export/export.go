@@ -0,0 +1,113 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"io"
+	"os"
+	"sync"
+)
+
+// Exporter writes converted logs as newline delimited JSON to a file or stdout.
+// It is safe for concurrent use.
+type Exporter struct {
+	mu sync.Mutex
+
+	file    *os.File
+	gzip    *gzip.Writer
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	total   int
+}
+
+// New creates an exporter writing to path, or to stdout when path is empty or "-".
+// When compress is set, the output is gzip compressed.
+func New(path string, compress bool) (*Exporter, error) {
+	exporter := Exporter{}
+
+	if path == "" || path == "-" {
+		exporter.file = os.Stdout
+	} else {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("could not create export file '%s': %v", path, err)
+		}
+
+		exporter.file = file
+	}
+
+	var writer io.Writer = exporter.file
+	if compress {
+		exporter.gzip = gzip.NewWriter(writer)
+		writer = exporter.gzip
+	}
+
+	exporter.buffer = bufio.NewWriter(writer)
+	exporter.encoder = json.NewEncoder(exporter.buffer)
+
+	return &exporter, nil
+}
+
+// Write appends logs to the export, one JSON object per line.
+func (e *Exporter) Write(logs []record.Record) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range logs {
+		if err := e.encoder.Encode(&logs[i]); err != nil {
+			return fmt.Errorf("could not write exported log: %v", err)
+		}
+
+		e.total++
+	}
+
+	return nil
+}
+
+func (e *Exporter) Name() string {
+	return "export"
+}
+
+// Send writes the logs of batch to the export.
+func (e *Exporter) Send(_ context.Context, batch sink.Batch) error {
+	return e.Write(batch.Logs)
+}
+
+// Total returns how many logs have been written so far.
+func (e *Exporter) Total() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.total
+}
+
+// Close flushes all pending output and closes the underlying file.
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.buffer.Flush(); err != nil {
+		return fmt.Errorf("could not flush export: %v", err)
+	}
+
+	if e.gzip != nil {
+		if err := e.gzip.Close(); err != nil {
+			return fmt.Errorf("could not finish gzip stream: %v", err)
+		}
+	}
+
+	if e.file == os.Stdout {
+		return nil
+	}
+
+	if err := e.file.Close(); err != nil {
+		return fmt.Errorf("could not close export file: %v", err)
+	}
+
+	return nil
+}
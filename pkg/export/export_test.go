@@ -0,0 +1,94 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readNDJSON(t *testing.T, reader io.Reader) []record.Record {
+	t.Helper()
+
+	var logs []record.Record
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var log record.Record
+		if err := json.Unmarshal(scanner.Bytes(), &log); err != nil {
+			t.Fatalf("line is not valid json: %v", err)
+		}
+
+		logs = append(logs, log)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return logs
+}
+
+func TestExporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+	}{
+		{name: "plain"},
+		{name: "gzip", compress: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "export.ndjson")
+
+			exporter, err := New(path, tt.compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := exporter.Write([]record.Record{{"UUID": "a1"}, {"UUID": "a2"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := exporter.Send(context.Background(), sink.Batch{Logs: []record.Record{{"UUID": "a3"}}}); err != nil {
+				t.Fatal(err)
+			}
+
+			if exporter.Total() != 3 {
+				t.Fatalf("expected a total of 3, got %d", exporter.Total())
+			}
+
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			var reader io.Reader = file
+			if tt.compress {
+				gz, err := gzip.NewReader(file)
+				if err != nil {
+					t.Fatalf("export is not gzip compressed: %v", err)
+				}
+
+				reader = gz
+			}
+
+			logs := readNDJSON(t, reader)
+			if len(logs) != 3 || logs[0]["UUID"] != "a1" || logs[2]["UUID"] != "a3" {
+				t.Fatalf("unexpected export: %v", logs)
+			}
+		})
+	}
+}
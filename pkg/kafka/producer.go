@@ -0,0 +1,235 @@
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTopic = "onepassword-{stream}"
+
+	// Event Hubs accepts at most 1MB per batch
+	defaultMaxBatchBytes = 1000 * 1000
+	defaultBatchTimeout  = 10 * time.Millisecond
+
+	headerStream  = "stream"
+	headerAccount = "account"
+)
+
+// Options configure the Kafka sink.
+type Options struct {
+	Brokers []string
+	// topic name with {stream} and {account} placeholders
+	Topic string
+
+	// use the actor UUID as message key, so the events of a user end up in one partition and stay in order
+	PartitionByActor bool
+
+	// plain, scram-sha-256 or scram-sha-512, empty disables SASL
+	SASLMechanism string
+	Username      string
+	Password      secret.Func
+
+	TLS bool
+	// CA bundle to verify the brokers with instead of the system roots
+	CAFile string
+
+	MaxBatchBytes int
+	Retry         sink.Retry
+}
+
+// messageWriter is the part of kafkago.Writer used by the producer, so tests can stand in for the brokers.
+type messageWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafkago.Message) error
+	Close() error
+}
+
+// Producer writes logs as JSON messages to a Kafka topic, which includes Azure Event Hubs through its Kafka endpoint.
+// Send only returns once all in-sync replicas acknowledged the messages.
+type Producer struct {
+	logger *logrus.Logger
+	opts   Options
+	writer messageWriter
+}
+
+func New(logger *logrus.Logger, opts Options) (*Producer, error) {
+	if len(opts.Brokers) == 0 {
+		return nil, errors.New("no kafka brokers provided")
+	}
+
+	if opts.Topic == "" {
+		opts.Topic = defaultTopic
+	}
+
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = defaultMaxBatchBytes
+	}
+
+	transport := &kafkago.Transport{ClientID: "one2sen"}
+
+	if opts.SASLMechanism != "" {
+		mechanism, err := newMechanism(opts.SASLMechanism, opts.Username, opts.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		transport.SASL = mechanism
+	}
+
+	if opts.TLS {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if opts.CAFile != "" {
+			pem, err := os.ReadFile(opts.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not read kafka ca file: %v", err)
+			}
+
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+			}
+		}
+
+		transport.TLS = tlsConfig
+	}
+
+	return &Producer{
+		logger: logger,
+		opts:   opts,
+		writer: &kafkago.Writer{
+			Addr: kafkago.TCP(opts.Brokers...),
+			// murmur2 matches the default partitioner of the Java client, messages without key are spread randomly
+			Balancer:     &kafkago.Murmur2Balancer{},
+			RequiredAcks: kafkago.RequireAll,
+			BatchBytes:   int64(opts.MaxBatchBytes),
+			BatchTimeout: defaultBatchTimeout,
+			// retries are done by Send, so only the failed messages are written again
+			MaxAttempts: 1,
+			Transport:   transport,
+		},
+	}, nil
+}
+
+func (p *Producer) Name() string {
+	return "kafka"
+}
+
+func (p *Producer) Close() error {
+	return p.writer.Close()
+}
+
+// Send produces a message for every log of batch and retries the messages which were not acknowledged.
+// Errors which producing again cannot resolve, such as a message over the size limit or a missing topic permission, are not retried.
+func (p *Producer) Send(ctx context.Context, batch sink.Batch) error {
+	messages, err := p.messages(batch)
+	if err != nil {
+		return err
+	}
+
+	err = p.opts.Retry.Do(ctx, p.logger, "kafka", func() error {
+		err := p.writer.WriteMessages(ctx, messages...)
+		if err == nil {
+			return nil
+		}
+
+		// only produce the messages which were not acknowledged again
+		var writeErrs kafkago.WriteErrors
+		if !errors.As(err, &writeErrs) {
+			if isPermanent(err) {
+				return sink.Permanent(fmt.Errorf("%d messages were rejected: %w", len(messages), err))
+			}
+
+			return fmt.Errorf("%d messages were not acknowledged: %w", len(messages), err)
+		}
+
+		failed := make([]kafkago.Message, 0, writeErrs.Count())
+		for i, writeErr := range writeErrs {
+			if writeErr == nil {
+				continue
+			}
+
+			if isPermanent(writeErr) {
+				return sink.Permanent(fmt.Errorf("message for topic %s was rejected: %w", messages[i].Topic, writeErr))
+			}
+
+			failed = append(failed, messages[i])
+		}
+
+		messages = failed
+
+		return fmt.Errorf("%d messages were not acknowledged: %w", len(messages), err)
+	})
+	if err != nil {
+		return err
+	}
+
+	p.logger.WithField("module", "kafka").WithField("stream", batch.Stream).
+		WithField("total", len(batch.Logs)).Debug("produced logs")
+
+	return nil
+}
+
+func (p *Producer) messages(batch sink.Batch) ([]kafkago.Message, error) {
+	topic := strings.NewReplacer("{stream}", batch.Stream, "{account}", batch.Account).Replace(p.opts.Topic)
+	messages := make([]kafkago.Message, 0, len(batch.Logs))
+
+	for _, log := range batch.Logs {
+		value, err := json.Marshal(log)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode log: %v", err)
+		}
+
+		message := kafkago.Message{
+			Topic: topic,
+			Value: value,
+			Headers: []kafkago.Header{
+				{Key: headerStream, Value: []byte(batch.Stream)},
+				{Key: headerAccount, Value: []byte(batch.Account)},
+			},
+		}
+
+		if p.opts.PartitionByActor {
+			var normalized map[string]any
+			if err := json.Unmarshal(value, &normalized); err != nil {
+				return nil, fmt.Errorf("could not decode log: %v", err)
+			}
+
+			if actor := record.LookupString(normalized, "ActorUUID", "Data.ActorUUID"); actor != "" {
+				message.Key = []byte(actor)
+			}
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// isPermanent reports whether err is an error which producing the same messages again will run into again.
+// Connection failures and the errors Kafka marks as retriable, such as a leader election, are temporary.
+func isPermanent(err error) bool {
+	var tooLarge kafkago.MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return true
+	}
+
+	var kafkaErr kafkago.Error
+	if errors.As(err, &kafkaErr) {
+		return !kafkaErr.Temporary()
+	}
+
+	return false
+}
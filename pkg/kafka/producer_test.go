@@ -0,0 +1,173 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProducer_Messages(t *testing.T) {
+	producer, err := New(logrus.New(), Options{Brokers: []string{"127.0.0.1:9092"}, Topic: "{account}.{stream}", PartitionByActor: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signins, err := onepassword.ConvertSigninToMap(nil, []onepassword.Event{
+		{UUID: "e1", Timestamp: "2024-01-02T03:04:05Z", TargetUser: onepassword.TargetUser{UUID: "u1"}},
+		{UUID: "e2", Timestamp: "2024-01-02T03:04:06Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := producer.messages(sink.Batch{Account: "eu", Stream: onepassword.StreamSignins, Logs: signins})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 || messages[0].Topic != "eu.signinattempts" || string(messages[0].Key) != "u1" {
+		t.Fatalf("unexpected messages: %+v", messages)
+	}
+
+	// events without actor are spread over the partitions
+	if messages[1].Key != nil {
+		t.Fatalf("expected no key: %s", messages[1].Key)
+	}
+
+	var log map[string]any
+	if err := json.Unmarshal(messages[0].Value, &log); err != nil || log["TimeGenerated"] != "2024-01-02T03:04:05Z" {
+		t.Fatalf("unexpected value: %s", messages[0].Value)
+	}
+}
+
+// fakeWriter stands in for the brokers, failing the messages of every call with the errors in results.
+type fakeWriter struct {
+	results [][]error
+	writes  [][]string
+}
+
+func (w *fakeWriter) WriteMessages(_ context.Context, messages ...kafkago.Message) error {
+	var values []string
+	for _, message := range messages {
+		values = append(values, string(message.Key))
+	}
+	w.writes = append(w.writes, values)
+
+	if len(w.results) == 0 {
+		return nil
+	}
+
+	result := w.results[0]
+	w.results = w.results[1:]
+
+	if len(result) == 1 && len(messages) != 1 {
+		return result[0]
+	}
+
+	return kafkago.WriteErrors(result)
+}
+
+func (w *fakeWriter) Close() error {
+	return nil
+}
+
+func TestProducer_Send(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   [][]error
+		writes    int
+		lastWrite []string
+		permanent bool
+	}{
+		{name: "acknowledged", writes: 1, lastWrite: []string{"u1", "u2", "u3"}},
+		{name: "only failed messages are produced again",
+			results:   [][]error{{nil, kafkago.NotEnoughReplicas, kafkago.LeaderNotAvailable}, {nil, kafkago.RequestTimedOut}},
+			writes:    3,
+			lastWrite: []string{"u3"}},
+		{name: "connection failure", results: [][]error{{io.ErrUnexpectedEOF}}, writes: 2, lastWrite: []string{"u1", "u2", "u3"}},
+		{name: "message too large",
+			results:   [][]error{{kafkago.MessageTooLargeError{}}},
+			writes:    1,
+			permanent: true},
+		{name: "topic authorization",
+			results:   [][]error{{nil, kafkago.TopicAuthorizationFailed, kafkago.NotEnoughReplicas}},
+			writes:    1,
+			permanent: true},
+	}
+
+	signins, err := onepassword.ConvertSigninToFlatMap(nil, []onepassword.Event{
+		{UUID: "e1", Timestamp: "2024-01-02T03:04:05Z", TargetUser: onepassword.TargetUser{UUID: "u1"}},
+		{UUID: "e2", Timestamp: "2024-01-02T03:04:06Z", TargetUser: onepassword.TargetUser{UUID: "u2"}},
+		{UUID: "e3", Timestamp: "2024-01-02T03:04:07Z", TargetUser: onepassword.TargetUser{UUID: "u3"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			producer, err := New(logrus.New(), Options{Brokers: []string{"127.0.0.1:9092"}, PartitionByActor: true, Retry: sink.Retry{BaseDelay: time.Millisecond}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Send only succeeds once every in-sync replica acknowledged the messages
+			if acks := producer.writer.(*kafkago.Writer).RequiredAcks; acks != kafkago.RequireAll {
+				t.Fatalf("unexpected required acks: %v", acks)
+			}
+
+			writer := &fakeWriter{results: tt.results}
+			producer.writer = writer
+
+			err = producer.Send(context.Background(), sink.Batch{Account: "eu", Stream: onepassword.StreamSignins, Logs: signins})
+			if tt.permanent != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(writer.writes) != tt.writes {
+				t.Fatalf("expected %d writes, got %v", tt.writes, writer.writes)
+			}
+
+			if !tt.permanent && !reflect.DeepEqual(writer.writes[len(writer.writes)-1], tt.lastWrite) {
+				t.Fatalf("unexpected last write: %v", writer.writes[len(writer.writes)-1])
+			}
+		})
+	}
+}
+
+func TestSecretMechanism(t *testing.T) {
+	if _, err := newMechanism("gssapi", "user", secret.Static("pass")); err == nil {
+		t.Fatal("expected an unknown mechanism to fail")
+	}
+
+	mechanism, err := newMechanism(MechanismPlain, "$ConnectionString", secret.Static("Endpoint=sb://example/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, initial, err := mechanism.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(initial) != "\x00$ConnectionString\x00Endpoint=sb://example/" {
+		t.Fatalf("unexpected initial response: %q", initial)
+	}
+
+	scram, err := newMechanism(MechanismScramSHA512, "user", secret.Static("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scram.Name() != "SCRAM-SHA-512" {
+		t.Fatalf("unexpected mechanism name: %s", scram.Name())
+	}
+}
@@ -0,0 +1,74 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"strings"
+)
+
+const (
+	MechanismPlain       = "plain"
+	MechanismScramSHA256 = "scram-sha-256"
+	MechanismScramSHA512 = "scram-sha-512"
+)
+
+// secretMechanism builds the SASL mechanism with the current password for every new connection.
+type secretMechanism struct {
+	name     string
+	username string
+	password secret.Func
+	build    func(username, password string) (sasl.Mechanism, error)
+}
+
+func newMechanism(name, username string, password secret.Func) (sasl.Mechanism, error) {
+	if username == "" || password == nil {
+		return nil, errors.New("kafka sasl authentication needs a username and password")
+	}
+
+	m := &secretMechanism{username: username, password: password}
+
+	switch strings.ToLower(name) {
+	case MechanismPlain:
+		m.name = "PLAIN"
+		m.build = func(username, password string) (sasl.Mechanism, error) {
+			return plain.Mechanism{Username: username, Password: password}, nil
+		}
+	case MechanismScramSHA256:
+		m.name = scram.SHA256.Name()
+		m.build = func(username, password string) (sasl.Mechanism, error) {
+			return scram.Mechanism(scram.SHA256, username, password)
+		}
+	case MechanismScramSHA512:
+		m.name = scram.SHA512.Name()
+		m.build = func(username, password string) (sasl.Mechanism, error) {
+			return scram.Mechanism(scram.SHA512, username, password)
+		}
+	default:
+		return nil, fmt.Errorf("unknown kafka sasl mechanism '%s', use %s, %s or %s", name, MechanismPlain, MechanismScramSHA256, MechanismScramSHA512)
+	}
+
+	return m, nil
+}
+
+func (m *secretMechanism) Name() string {
+	return m.name
+}
+
+func (m *secretMechanism) Start(ctx context.Context) (sasl.StateMachine, []byte, error) {
+	password, err := m.password(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get kafka password: %v", err)
+	}
+
+	mechanism, err := m.build(m.username, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create kafka sasl mechanism: %v", err)
+	}
+
+	return mechanism.Start(ctx)
+}
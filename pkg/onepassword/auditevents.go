@@ -1,22 +1,13 @@
 package onepassword
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
 	"time"
 )
 
-type auditEventResponse struct {
-	Cursor  string       `json:"cursor"`
-	HasMore bool         `json:"has_more"`
-	Items   []AuditEvent `json:"items"`
-}
-
 type ActorDetails struct {
-	UUID  string `json:"uuid:"`
+	UUID  string `json:"uuid"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
 }
@@ -34,15 +25,6 @@ type Session struct {
 	IP         string `json:"ip"`
 }
 
-/*
-type Location struct {
-	Country   string  `json:"country"`
-	Region    string  `json:"region"`
-	City      string  `json:"city"`
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
-}*/
-
 type AuditEvent struct {
 	UUID         string       `json:"uuid"`
 	Timestamp    string       `json:"timestamp"`
@@ -57,75 +39,34 @@ type AuditEvent struct {
 	AuxInfo      string       `json:"aux_info"`
 	Session      Session      `json:"session"`
 	Location     Location     `json:"location"`
-}
-
-func (p *OnePassword) GetAuditEvents(lookBack time.Duration) ([]AuditEvent, error) {
-	items := make([]AuditEvent, 0)
-
-	now := time.Now().UTC()
-	startTime := now.Add(-lookBack)
-
-	round := 0
-	hasMore := true
-	cursor := ""
-
-	for hasMore {
-		round++
-		p.Logger.WithField("round", round).Debug("fetching usage events")
-
-		payload := eventRequest{}
-		if cursor != "" {
-			payload.Cursor = cursor
-		} else {
-			payload.Limit = maxFetch
-			payload.StartTime = startTime.Format(onePasswordTimestampFormat)
-			payload.EndTime = now.Format(onePasswordTimestampFormat)
-		}
-
-		payloadBytes, err := json.Marshal(&payload)
-		if err != nil {
-			return nil, fmt.Errorf("could not encode payload: %v", err)
-		}
 
-		p.Logger.Debugf("%s", payloadBytes)
-
-		usagesRequest, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/auditevents", p.apiURL), bytes.NewBuffer(payloadBytes))
-		if err != nil {
-			return nil, fmt.Errorf("could not create usage request: %v", err)
-		}
-
-		usagesRequest.Header.Set("Content-Type", "application/json")
-		usagesRequest.Header.Set("Authorization", "Bearer "+p.apiToken)
-
-		usagesResponse, usagesError := p.httpClient.Do(usagesRequest)
-		if usagesError != nil {
-			return nil, fmt.Errorf("could not fetch usage: %v", err)
-		}
-
-		if usagesResponse.StatusCode > 399 {
-			_ = usagesResponse.Body.Close()
-			return nil, fmt.Errorf("returned status code: %d", usagesResponse.StatusCode)
-		}
+	// Raw holds the event exactly as it was returned by the Events API
+	Raw json.RawMessage `json:"-"`
+}
 
-		usagesBody, err := io.ReadAll(usagesResponse.Body)
-		_ = usagesResponse.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("could not read usage: %v", err)
-		}
+func (a *AuditEvent) UnmarshalJSON(data []byte) error {
+	type plain AuditEvent
+	if err := json.Unmarshal(data, (*plain)(a)); err != nil {
+		return err
+	}
 
-		var resp auditEventResponse
+	a.Raw = append(json.RawMessage(nil), data...)
 
-		if err := json.Unmarshal(usagesBody, &resp); err != nil {
-			return nil, fmt.Errorf("could not decode usage response: %v", err)
-		}
+	return nil
+}
 
-		hasMore = resp.HasMore
-		cursor = resp.Cursor
+func (a AuditEvent) RawJSON() json.RawMessage {
+	return a.Raw
+}
 
-		items = append(items, resp.Items...)
-	}
+func (a AuditEvent) EventID() string {
+	return a.UUID
+}
 
-	p.Logger.WithField("total", len(items)).Debug("retrieved audit events")
+func (a AuditEvent) EventTime() (time.Time, error) {
+	return ParseTimestamp(a.Timestamp)
+}
 
-	return items, nil
+func (p *OnePassword) GetAuditEvents(ctx context.Context, lookback time.Duration, cursor string) ([]AuditEvent, string, error) {
+	return collect[AuditEvent](ctx, p, StreamAudit, lookback, cursor)
 }
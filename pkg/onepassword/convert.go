@@ -3,201 +3,313 @@ package onepassword
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
 	"github.com/sirupsen/logrus"
 	"time"
 )
 
+const (
+	// RawEventColumn holds the event as it was returned by the 1Password Events API
+	RawEventColumn = "RawEvent"
+	// AccountColumn holds the name of the 1Password account the event was collected from
+	AccountColumn = "Account"
+)
+
 const (
 	onePasswordEventTimestampFormat = "2006-01-02T15:04:05.99999999Z"
 	onePasswordTimestampFormat      = "2006-01-02T15:04:05-07:00"
 	iso8601Format                   = "2006-01-02T15:04:05Z"
 )
 
-func toJson(obj interface{}) (string, error) {
-	switch _, ok := obj.(string); {
-	case ok:
-		return obj.(string), nil
-	}
-
-	b, err := json.Marshal(&obj)
+// ParseTimestamp parses an event timestamp as returned by the 1Password Events API.
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	t, err := time.Parse(onePasswordEventTimestampFormat, timestamp)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal object: %w", err)
+		return time.Time{}, fmt.Errorf("failed to parse 1P event timestamp: %w", err)
 	}
 
-	return string(b), nil
+	return t.UTC(), nil
 }
 
-func ConvertUsageToMap(_ *logrus.Logger, items []Item) ([]map[string]string, error) {
-	logs := make([]map[string]string, len(items))
+// ParseTimeGenerated parses the TimeGenerated column of a converted log.
+func ParseTimeGenerated(value string) (time.Time, error) {
+	t, err := time.Parse(iso8601Format, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse event time '%s': %w", value, err)
+	}
 
-	var err error
+	return t, nil
+}
 
-	for i, item := range items {
-		cols := make(map[string]string)
+// APIEvent is implemented by every event type returned by the 1Password Events API.
+type APIEvent interface {
+	EventID() string
+	EventTime() (time.Time, error)
+	RawJSON() json.RawMessage
+}
 
-		cols["LogType"] = "Usage"
+// LatestEventTime returns the most recent event time out of events, or since if none are more recent.
+func LatestEventTime[T APIEvent](since time.Time, events []T) time.Time {
+	latest := since
 
-		// YYYY-MM-DDThh:mm:ssZ
-		timeGenerated, err := time.Parse(onePasswordEventTimestampFormat, item.Timestamp)
+	for _, event := range events {
+		eventTime, err := event.EventTime()
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse 1P event timestamp: %w", err)
+			continue
 		}
 
-		cols["TimeGenerated"] = timeGenerated.UTC().Format(iso8601Format)
-
-		cols["User"], err = toJson(item.User)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal User: %v", err)
+		if eventTime.After(latest) {
+			latest = eventTime
 		}
+	}
 
-		cols["Client"], err = toJson(item.Client)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal Client: %v", err)
-		}
+	return latest
+}
 
-		cols["Location"], err = toJson(item.Location)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal Location: %v", err)
-		}
+func ConvertUsageToMap(_ *logrus.Logger, items []Item) ([]record.Record, error) {
+	logs := make([]record.Record, len(items))
 
-		// specific columns
-		custom := map[string]string{
-			"Action":     item.Action,
-			"VaultUUID":  item.VaultUUID,
-			"ItemUUID":   item.ItemUUID,
-			"ActorUUID":  item.User.UUID,
-			"ActorName":  item.User.Name,
-			"ActorEmail": item.User.Email,
-			"City":       item.Location.City,
-			"Country":    item.Location.Country,
-		}
-		cols["Data"], err = toJson(custom)
+	for i, item := range items {
+		// YYYY-MM-DDThh:mm:ssZ
+		timeGenerated, err := ParseTimestamp(item.Timestamp)
 		if err != nil {
-			return nil, fmt.Errorf("could not json marshal data: %v", err)
+			return nil, err
 		}
 
-		logs[i] = cols
+		logs[i] = record.Record{
+			"LogType":       "Usage",
+			"TimeGenerated": timeGenerated.Format(iso8601Format),
+			"User":          item.User,
+			"Client":        item.Client,
+			"Location":      item.Location,
+			// specific columns
+			"Data": map[string]any{
+				"UUID":        item.UUID,
+				"Action":      item.Action,
+				"VaultUUID":   item.VaultUUID,
+				"ItemUUID":    item.ItemUUID,
+				"UsedVersion": item.UsedVersion,
+				"ActorUUID":   item.User.UUID,
+				"ActorName":   item.User.Name,
+				"ActorEmail":  item.User.Email,
+				"City":        item.Location.City,
+				"Country":     item.Location.Country,
+			},
+		}
 	}
 
-	return logs, err
+	return logs, nil
 }
 
-func ConvertSigninToMap(_ *logrus.Logger, events []Event) ([]map[string]string, error) {
-	logs := make([]map[string]string, len(events))
-
-	var err error
+func ConvertSigninToMap(_ *logrus.Logger, events []Event) ([]record.Record, error) {
+	logs := make([]record.Record, len(events))
 
 	for i, event := range events {
-		cols := make(map[string]string)
-
-		cols["LogType"] = "Event"
-
-		timeGenerated, err := time.Parse(onePasswordEventTimestampFormat, event.Timestamp)
+		timeGenerated, err := ParseTimestamp(event.Timestamp)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse 1P event timestamp: %w", err)
+			return nil, err
 		}
 
-		cols["TimeGenerated"] = timeGenerated.UTC().Format(iso8601Format)
-		//cols["UUID"] = event.UUID
-
-		cols["User"], err = toJson(event.TargetUser)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal User: %v", err)
+		logs[i] = record.Record{
+			"LogType":       "Event",
+			"TimeGenerated": timeGenerated.Format(iso8601Format),
+			"User":          event.TargetUser,
+			"Client":        event.Client,
+			"Location":      event.Location,
+			// specific columns
+			"Data": map[string]any{
+				"UUID":         event.UUID,
+				"Category":     event.Category,
+				"EventCountry": event.Country,
+				"OK":           event.IsOK(),
+				"Details":      event.Details,
+				"SessionUUID":  event.SessionUUID,
+				"EventType":    event.Type,
+				"ActorUUID":    event.TargetUser.UUID,
+				"ActorName":    event.TargetUser.Name,
+				"ActorEmail":   event.TargetUser.Email,
+				"City":         event.Location.City,
+				"Country":      event.Location.Country,
+			},
 		}
+	}
 
-		cols["Client"], err = toJson(event.Client)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal Client: %v", err)
-		}
+	return logs, nil
+}
 
-		cols["Location"], err = toJson(event.Location)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal Location: %v", err)
-		}
+func ConvertAuditEventToMap(_ *logrus.Logger, audits []AuditEvent) ([]record.Record, error) {
+	logs := make([]record.Record, len(audits))
 
-		// specific columns
-		eventDetails, err := toJson(event.Details)
+	for i, event := range audits {
+		timeGenerated, err := ParseTimestamp(event.Timestamp)
 		if err != nil {
-			return nil, fmt.Errorf("could not json marshal Details: %v", err)
+			return nil, err
 		}
-		custom := map[string]string{
-			"OK":          fmt.Sprintf("%t", event.IsOK()),
-			"Details":     eventDetails,
-			"SessionUUID": event.SessionUUID,
-			"EventType":   event.Type,
-			"ActorUUID":   event.TargetUser.UUID,
-			"ActorName":   event.TargetUser.Name,
-			"ActorEmail":  event.TargetUser.Email,
-			"City":        event.Location.City,
-			"Country":     event.Location.Country,
+
+		logs[i] = record.Record{
+			"LogType":       "Audit",
+			"TimeGenerated": timeGenerated.Format(iso8601Format),
+			"User":          event.ActorUUID,
+			"Location":      event.Location,
+			// specific columns
+			"Data": map[string]any{
+				"UUID":              event.UUID,
+				"Action":            event.Action,
+				"ActorUUID":         event.ActorUUID,
+				"ActorName":         event.ActorDetails.Name,
+				"ActorEmail":        event.ActorDetails.Email,
+				"ActorDetails":      event.ActorDetails,
+				"City":              event.Location.City,
+				"Country":           event.Location.Country,
+				"ObjectType":        event.ObjectType,
+				"ObjectUUID":        event.ObjectUUID,
+				"SessionUUID":       event.Session.UUID,
+				"SessionLoginTime":  event.Session.LoginTime,
+				"SessionDeviceUUID": event.Session.DeviceUUID,
+				"SessionIP":         event.Session.IP,
+				"AuxID":             event.AuxID,
+				"AuxUUID":           event.AuxUUID,
+				"AuxDetails":        event.AuxDetails,
+				"AuxInfo":           event.AuxInfo,
+			},
 		}
+	}
 
-		cols["Data"], err = toJson(custom)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal data: %v", err)
+	return logs, nil
+}
+
+// AddRawEvents adds the original JSON of every event to the RawEvent column of its converted record.
+// The records have to be converted from events in the same order.
+func AddRawEvents[T APIEvent](records []record.Record, events []T) {
+	for i := range records {
+		if i >= len(events) {
+			return
 		}
 
-		logs[i] = cols
+		if raw := events[i].RawJSON(); len(raw) > 0 {
+			records[i][RawEventColumn] = raw
+		}
 	}
-
-	return logs, err
 }
 
-func ConvertAuditEventToMap(_ *logrus.Logger, audits []AuditEvent) ([]map[string]string, error) {
-	logs := make([]map[string]string, len(audits))
+func flattenActor(cols record.Record, uuid, name, email string) {
+	cols["ActorUUID"] = uuid
+	cols["ActorName"] = name
+	cols["ActorEmail"] = email
+}
 
-	var err error
+func flattenClient(cols record.Record, client Client) {
+	cols["AppName"] = client.AppName
+	cols["AppVersion"] = client.AppVersion
+	cols["PlatformName"] = client.PlatformName
+	cols["PlatformVersion"] = client.PlatformVersion
+	cols["OSName"] = client.OsName
+	cols["OSVersion"] = client.OsVersion
+	cols["IPAddress"] = client.IPAddress
+}
 
-	for i, event := range audits {
-		cols := make(map[string]string)
+func flattenLocation(cols record.Record, location Location) {
+	cols["Country"] = location.Country
+	cols["Region"] = location.Region
+	cols["City"] = location.City
+	cols["Latitude"] = location.Latitude
+	cols["Longitude"] = location.Longitude
+}
 
-		cols["LogType"] = "Audit"
+// ConvertSigninToFlatMap converts sign-in attempts to one column per field, matching the dedicated signin table.
+func ConvertSigninToFlatMap(_ *logrus.Logger, events []Event) ([]record.Record, error) {
+	logs := make([]record.Record, len(events))
 
-		timeGenerated, err := time.Parse(onePasswordEventTimestampFormat, event.Timestamp)
+	for i, event := range events {
+		timeGenerated, err := ParseTimestamp(event.Timestamp)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse 1P event timestamp: %w", err)
+			return nil, err
+		}
+
+		cols := record.Record{
+			"TimeGenerated": timeGenerated.Format(iso8601Format),
+			"UUID":          event.UUID,
+			"SessionUUID":   event.SessionUUID,
+			"Category":      event.Category,
+			"EventType":     event.Type,
+			"EventCountry":  event.Country,
+			"OK":            event.IsOK(),
+			"Details":       event.Details,
 		}
 
-		cols["TimeGenerated"] = timeGenerated.UTC().Format(iso8601Format)
-		//cols["UUID"] = event.UUID
+		flattenActor(cols, event.TargetUser.UUID, event.TargetUser.Name, event.TargetUser.Email)
+		flattenClient(cols, event.Client)
+		flattenLocation(cols, event.Location)
+
+		logs[i] = cols
+	}
+
+	return logs, nil
+}
+
+// ConvertUsageToFlatMap converts item usages to one column per field, matching the dedicated item usage table.
+func ConvertUsageToFlatMap(_ *logrus.Logger, items []Item) ([]record.Record, error) {
+	logs := make([]record.Record, len(items))
 
-		cols["User"], err = toJson(event.ActorUUID)
+	for i, item := range items {
+		timeGenerated, err := ParseTimestamp(item.Timestamp)
 		if err != nil {
-			return nil, fmt.Errorf("could not json marshal User: %v", err)
+			return nil, err
 		}
 
-		cols["Location"], err = toJson(event.Location)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal Location: %v", err)
+		cols := record.Record{
+			"TimeGenerated": timeGenerated.Format(iso8601Format),
+			"UUID":          item.UUID,
+			"Action":        item.Action,
+			"VaultUUID":     item.VaultUUID,
+			"ItemUUID":      item.ItemUUID,
+			"UsedVersion":   item.UsedVersion,
 		}
 
-		auxDetails, err := toJson(event.AuxDetails)
+		flattenActor(cols, item.User.UUID, item.User.Name, item.User.Email)
+		flattenClient(cols, item.Client)
+		flattenLocation(cols, item.Location)
+
+		logs[i] = cols
+	}
+
+	return logs, nil
+}
+
+// ConvertAuditEventToFlatMap converts audit events to one column per field, matching the dedicated audit table.
+func ConvertAuditEventToFlatMap(_ *logrus.Logger, audits []AuditEvent) ([]record.Record, error) {
+	logs := make([]record.Record, len(audits))
+
+	for i, event := range audits {
+		timeGenerated, err := ParseTimestamp(event.Timestamp)
 		if err != nil {
-			return nil, fmt.Errorf("could not json marshal auxDetails: %v", err)
+			return nil, err
 		}
 
-		// specific columns
-		custom := map[string]string{
-			"Action":      event.Action,
-			"ActorUUID":   event.ActorUUID,
-			"ActorName":   event.ActorDetails.Name,
-			"ActorEmail":  event.ActorDetails.Email,
-			"City":        event.Location.City,
-			"Country":     event.Location.Country,
-			"ObjectType":  event.ObjectType,
-			"ObjectUUID":  event.ObjectUUID,
-			"SessionUUID": event.Session.UUID,
-			"AuxUUID":     event.AuxUUID,
-			"AuxDetails":  auxDetails,
+		cols := record.Record{
+			"TimeGenerated":     timeGenerated.Format(iso8601Format),
+			"UUID":              event.UUID,
+			"Action":            event.Action,
+			"ObjectType":        event.ObjectType,
+			"ObjectUUID":        event.ObjectUUID,
+			"SessionUUID":       event.Session.UUID,
+			"SessionLoginTime":  event.Session.LoginTime,
+			"SessionDeviceUUID": event.Session.DeviceUUID,
+			"SessionIP":         event.Session.IP,
+			"AuxID":             event.AuxID,
+			"AuxUUID":           event.AuxUUID,
+			"AuxDetailsUUID":    event.AuxDetails.UUID,
+			"AuxName":           event.AuxDetails.Name,
+			"AuxEmail":          event.AuxDetails.Email,
+			"AuxInfo":           event.AuxInfo,
+			"ActorDetailsUUID":  event.ActorDetails.UUID,
 		}
 
-		cols["Data"], err = toJson(custom)
-		if err != nil {
-			return nil, fmt.Errorf("could not json marshal data: %v", err)
-		}
+		flattenActor(cols, event.ActorUUID, event.ActorDetails.Name, event.ActorDetails.Email)
+		flattenLocation(cols, event.Location)
 
 		logs[i] = cols
 	}
 
-	return logs, err
+	return logs, nil
 }
@@ -0,0 +1,142 @@
+package onepassword
+
+import (
+	"encoding/json"
+	"github.com/hazcod/one2sen/pkg/record"
+	"testing"
+)
+
+// lookup reads a field from a converted log by its dotted path, as it arrives at the outputs.
+func lookup(t *testing.T, log record.Record, paths ...string) any {
+	t.Helper()
+
+	normalized, err := log.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return record.Lookup(normalized, paths...)
+}
+
+func TestConvert_PreservesFields(t *testing.T) {
+	var signins []Event
+	if err := json.Unmarshal([]byte(`[{"uuid":"e1","session_uuid":"s1","timestamp":"2024-01-02T03:04:05.123Z","country":"BE",
+		"category":"success","type":"credentials_ok","target_user":{"uuid":"u1","email":"jane@example.com"}}]`), &signins); err != nil {
+		t.Fatal(err)
+	}
+
+	var items []Item
+	if err := json.Unmarshal([]byte(`[{"uuid":"i1","timestamp":"2024-01-02T03:04:05Z","used_version":3,"vault_uuid":"v1",
+		"item_uuid":"it1","user":{"uuid":"u1"},"action":"reveal"}]`), &items); err != nil {
+		t.Fatal(err)
+	}
+
+	var audits []AuditEvent
+	if err := json.Unmarshal([]byte(`[{"uuid":"a1","timestamp":"2024-01-02T03:04:05Z","actor_uuid":"u1",
+		"actor_details":{"uuid":"u2","name":"Jane","email":"jane@example.com"},"action":"join","object_type":"gm",
+		"object_uuid":"o1","aux_id":42,"aux_uuid":"x1","aux_details":{"uuid":"x2","name":"John","email":"john@example.com"},
+		"aux_info":"A","session":{"uuid":"s1","login_time":"2024-01-02T03:00:00Z","device_uuid":"d1","ip":"192.0.2.1"}}]`), &audits); err != nil {
+		t.Fatal(err)
+	}
+
+	signinLogs, err := ConvertSigninToMap(nil, signins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signinFlat, err := ConvertSigninToFlatMap(nil, signins)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usageLogs, err := ConvertUsageToMap(nil, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usageFlat, err := ConvertUsageToFlatMap(nil, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditLogs, err := ConvertAuditEventToMap(nil, audits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditFlat, err := ConvertAuditEventToFlatMap(nil, audits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		logs  []record.Record
+		paths []string
+		want  any
+	}{
+		{signinLogs, []string{"Data.UUID"}, "e1"},
+		{signinFlat, []string{"UUID"}, "e1"},
+		{signinLogs, []string{"Data.SessionUUID"}, "s1"},
+		{signinFlat, []string{"SessionUUID"}, "s1"},
+		{signinLogs, []string{"Data.Category"}, "success"},
+		{signinFlat, []string{"Category"}, "success"},
+		{signinLogs, []string{"Data.EventCountry"}, "BE"},
+		{signinFlat, []string{"EventCountry"}, "BE"},
+		{signinLogs, []string{"Data.ActorUUID"}, "u1"},
+		{signinFlat, []string{"ActorUUID"}, "u1"},
+
+		{usageLogs, []string{"Data.UUID"}, "i1"},
+		{usageFlat, []string{"UUID"}, "i1"},
+		{usageLogs, []string{"Data.UsedVersion"}, float64(3)},
+		{usageFlat, []string{"UsedVersion"}, float64(3)},
+		{usageLogs, []string{"Data.ItemUUID"}, "it1"},
+		{usageFlat, []string{"ItemUUID"}, "it1"},
+		{usageLogs, []string{"Data.VaultUUID"}, "v1"},
+		{usageFlat, []string{"VaultUUID"}, "v1"},
+
+		{auditLogs, []string{"Data.ActorUUID"}, "u1"},
+		{auditFlat, []string{"ActorUUID"}, "u1"},
+		{auditLogs, []string{"Data.ActorDetails.uuid"}, "u2"},
+		{auditFlat, []string{"ActorDetailsUUID"}, "u2"},
+		{auditLogs, []string{"Data.AuxID"}, float64(42)},
+		{auditFlat, []string{"AuxID"}, float64(42)},
+		{auditLogs, []string{"Data.AuxUUID"}, "x1"},
+		{auditFlat, []string{"AuxUUID"}, "x1"},
+		{auditLogs, []string{"Data.AuxDetails.uuid"}, "x2"},
+		{auditFlat, []string{"AuxDetailsUUID"}, "x2"},
+		{auditLogs, []string{"Data.AuxDetails.email"}, "john@example.com"},
+		{auditFlat, []string{"AuxEmail"}, "john@example.com"},
+		{auditLogs, []string{"Data.AuxInfo"}, "A"},
+		{auditFlat, []string{"AuxInfo"}, "A"},
+		{auditLogs, []string{"Data.SessionUUID"}, "s1"},
+		{auditFlat, []string{"SessionUUID"}, "s1"},
+		{auditLogs, []string{"Data.SessionLoginTime"}, "2024-01-02T03:00:00Z"},
+		{auditFlat, []string{"SessionLoginTime"}, "2024-01-02T03:00:00Z"},
+		{auditLogs, []string{"Data.SessionDeviceUUID"}, "d1"},
+		{auditFlat, []string{"SessionDeviceUUID"}, "d1"},
+		{auditLogs, []string{"Data.SessionIP"}, "192.0.2.1"},
+		{auditFlat, []string{"SessionIP"}, "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		if got := lookup(t, tt.logs[0], tt.paths...); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestAddRawEvents(t *testing.T) {
+	var audits []AuditEvent
+	if err := json.Unmarshal([]byte(`[{"uuid":"a1","timestamp":"2024-01-02T03:04:05Z","unknown_field":true}]`), &audits); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := ConvertAuditEventToFlatMap(nil, audits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	AddRawEvents(logs, audits)
+
+	// fields the converters do not know survive in the raw event
+	if raw := lookup(t, logs[0], RawEventColumn+".unknown_field"); raw != true {
+		t.Fatalf("unexpected raw event: %s", logs[0][RawEventColumn])
+	}
+}
@@ -0,0 +1,49 @@
+package onepassword
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"net/http"
+)
+
+// Errors that can be matched with errors.Is against errors returned by the 1Password client.
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrServer       = errors.New("server error")
+
+	// errTransport marks network errors which are worth retrying
+	errTransport = errors.New("transport error")
+)
+
+// StatusError is returned when the 1Password Events API responds with an unsuccessful status code.
+// It wraps a sink.StatusError, which decides whether the request is retried.
+type StatusError struct {
+	Endpoint string
+	sink.StatusError
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status code: %d", e.Endpoint, e.StatusCode)
+}
+
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrServer:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+
+	return false
+}
+
+func (e *StatusError) Unwrap() error {
+	return &e.StatusError
+}
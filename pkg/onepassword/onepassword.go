@@ -2,6 +2,8 @@ package onepassword
 
 import (
 	"errors"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
 	"github.com/hazcod/one2sen/pkg/utils"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -11,15 +13,24 @@ const (
 	maxFetch = 100
 )
 
+// The event streams exposed by the 1Password Events API.
+const (
+	StreamSignins = "signinattempts"
+	StreamUsage   = "itemusages"
+	StreamAudit   = "auditevents"
+)
+
 type OnePassword struct {
 	Logger     *logrus.Logger
-	apiToken   string
+	apiToken   secret.Func
 	httpClient *http.Client
 	apiURL     string
+	retry      sink.Retry
 }
 
-func New(l *logrus.Logger, tenantURL string, apiToken string) (*OnePassword, error) {
-	if apiToken == "" {
+// New creates a 1Password Events API client, apiToken is called for every request so a rotated token is picked up.
+func New(l *logrus.Logger, tenantURL string, apiToken secret.Func) (*OnePassword, error) {
+	if apiToken == nil {
 		return nil, errors.New("empty api token provided")
 	}
 
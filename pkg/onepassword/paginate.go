@@ -0,0 +1,169 @@
+package onepassword
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"io"
+	"net/http"
+	"time"
+)
+
+type pageResponse[T any] struct {
+	Cursor  string `json:"cursor"`
+	HasMore bool   `json:"has_more"`
+	Items   []T    `json:"items"`
+	Error   struct {
+		Message string `json:"Message"`
+	} `json:"Error"`
+}
+
+// PageFunc is called for every page of events as it arrives, together with the cursor to resume after that page.
+// Returning an error stops pagination.
+type PageFunc[T any] func(items []T, cursor string) error
+
+// Paginate walks all pages of a 1Password Events API endpoint such as StreamSignins and calls fn for every page.
+// When cursor is empty, events of the last lookback are requested, otherwise pagination resumes from cursor.
+// It returns the cursor to resume from on the next call.
+func Paginate[T any](ctx context.Context, p *OnePassword, endpoint string, lookback time.Duration, cursor string, fn PageFunc[T]) (string, error) {
+	logger := p.Logger.WithField("endpoint", endpoint)
+
+	now := time.Now().UTC()
+	startTime := now.Add(-lookback)
+
+	round := 0
+	total := 0
+	hasMore := true
+
+	for hasMore {
+		round++
+		logger.WithField("round", round).Debug("fetching events")
+
+		payload := eventRequest{}
+		if cursor != "" {
+			payload.Cursor = cursor
+		} else {
+			payload.Limit = maxFetch
+			payload.StartTime = startTime.Format(onePasswordTimestampFormat)
+			payload.EndTime = now.Format(onePasswordTimestampFormat)
+		}
+
+		resp, err := fetchPage[T](ctx, p, endpoint, payload)
+		if err != nil {
+			return "", err
+		}
+
+		hasMore = resp.HasMore
+		if resp.Cursor != "" {
+			cursor = resp.Cursor
+		}
+
+		total += len(resp.Items)
+
+		if err := fn(resp.Items, cursor); err != nil {
+			return "", err
+		}
+	}
+
+	logger.WithField("total", total).Debug("retrieved events")
+
+	return cursor, nil
+}
+
+// fetchPage requests a single page and retries rate limited, server and network errors with backoff.
+// Since the payload holds the cursor, a retried request resumes from the same position.
+func fetchPage[T any](ctx context.Context, p *OnePassword, endpoint string, payload eventRequest) (*pageResponse[T], error) {
+	var resp *pageResponse[T]
+
+	err := p.retry.Do(ctx, p.Logger, "onepassword", func() error {
+		var err error
+		resp, err = doFetchPage[T](ctx, p, endpoint, payload)
+
+		var statusErr *StatusError
+		if err == nil || errors.As(err, &statusErr) || errors.Is(err, errTransport) {
+			return err
+		}
+
+		return sink.Permanent(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func doFetchPage[T any](ctx context.Context, p *OnePassword, endpoint string, payload eventRequest) (*pageResponse[T], error) {
+	payloadBytes, err := json.Marshal(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode payload: %v", err)
+	}
+
+	p.Logger.Tracef("%s", payloadBytes)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v1/%s", p.apiURL, endpoint), bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not create %s request: %v", endpoint, err)
+	}
+
+	apiToken, err := p.apiToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get api token: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiToken)
+
+	httpResp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch %s: %w: %v", endpoint, errTransport, err)
+	}
+
+	if httpResp.StatusCode > 399 {
+		_ = httpResp.Body.Close()
+		return nil, &StatusError{
+			Endpoint: endpoint,
+			StatusError: sink.StatusError{
+				StatusCode: httpResp.StatusCode,
+				RetryAfter: utils.ParseRetryAfter(httpResp.Header.Get("Retry-After")),
+			},
+		}
+	}
+
+	body, err := io.ReadAll(httpResp.Body)
+	_ = httpResp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s response body: %w: %v", endpoint, errTransport, err)
+	}
+
+	var resp pageResponse[T]
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("could not decode %s response: %v", endpoint, err)
+	}
+
+	if resp.Error.Message != "" {
+		return nil, fmt.Errorf("%s returned error: %v", endpoint, resp.Error.Message)
+	}
+
+	return &resp, nil
+}
+
+// collect gathers all events of endpoint into a single slice.
+func collect[T any](ctx context.Context, p *OnePassword, endpoint string, lookback time.Duration, cursor string) ([]T, string, error) {
+	items := make([]T, 0)
+
+	cursor, err := Paginate(ctx, p, endpoint, lookback, cursor, func(page []T, _ string) error {
+		items = append(items, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return items, cursor, nil
+}
@@ -0,0 +1,126 @@
+package onepassword
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPaginate(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"cursor":"c1","has_more":true,"items":[{"uuid":"a"},{"uuid":"b"}]}`,
+		"c1": `{"cursor":"c2","has_more":false,"items":[{"uuid":"c"}]}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/"+StreamSignins {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		var req eventRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+
+		_, _ = w.Write([]byte(pages[req.Cursor]))
+	}))
+	defer server.Close()
+
+	p, err := New(logrus.New(), server.URL, secret.Static("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var uuids []string
+	cursor, err := Paginate(context.Background(), p, StreamSignins, time.Hour, "", func(items []Event, _ string) error {
+		for _, item := range items {
+			uuids = append(uuids, item.UUID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cursor != "c2" || len(uuids) != 3 {
+		t.Fatalf("unexpected result: cursor=%s uuids=%v", cursor, uuids)
+	}
+}
+
+func TestPaginate_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"Error":{"Message":"invalid token"}}`))
+	}))
+	defer server.Close()
+
+	p, err := New(logrus.New(), server.URL, secret.Static("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := p.GetAuditEvents(context.Background(), time.Hour, ""); err == nil {
+		t.Fatal("expected error message to be returned")
+	}
+}
+
+func TestPaginate_Retry(t *testing.T) {
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"cursor":"c1","has_more":false,"items":[{"uuid":"a"}]}`))
+	}))
+	defer server.Close()
+
+	p, err := New(logrus.New(), server.URL, secret.Static("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.retry.BaseDelay = time.Millisecond
+
+	items, cursor, err := p.GetUsage(context.Background(), time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 || cursor != "c1" || len(items) != 1 {
+		t.Fatalf("unexpected result: calls=%d cursor=%s items=%d", calls, cursor, len(items))
+	}
+}
+
+func TestPaginate_Unauthorized(t *testing.T) {
+	calls := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	p, err := New(logrus.New(), server.URL, secret.Static("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = p.GetSigninEvents(context.Background(), time.Hour, "")
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("unauthorized requests should not be retried, got %d calls", calls)
+	}
+}
@@ -1,24 +1,12 @@
 package onepassword
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
 	"strings"
 	"time"
 )
 
-type eventResponse struct {
-	Cursor  string  `json:"cursor"`
-	HasMore bool    `json:"has_more"`
-	Items   []Event `json:"items"`
-	Error   struct {
-		Message string `json:"Message"`
-	} `json:"Error"`
-}
-
 type TargetUser struct {
 	UUID  string `json:"uuid"`
 	Name  string `json:"name"`
@@ -36,81 +24,38 @@ type Event struct {
 	TargetUser  TargetUser  `json:"target_user"`
 	Client      Client      `json:"client"`
 	Location    Location    `json:"location"`
-}
 
-func (e *Event) IsOK() bool {
-	return strings.Contains(strings.ToLower(e.Type), "_ok")
+	// Raw holds the event exactly as it was returned by the Events API
+	Raw json.RawMessage `json:"-"`
 }
 
-func (p *OnePassword) GetSigninEvents(lookback time.Duration) ([]Event, error) {
-	items := make([]Event, 0)
-
-	now := time.Now().UTC()
-	startTime := now.Add(-lookback)
-
-	round := 0
-	hasMore := true
-	cursor := ""
-
-	for hasMore {
-		round++
-		p.Logger.WithField("round", round).Debug("fetching signin events")
-
-		payload := eventRequest{}
-		if cursor != "" {
-			payload.Cursor = cursor
-		} else {
-			payload.Limit = maxFetch
-			payload.StartTime = startTime.Format(onePasswordTimestampFormat)
-			payload.EndTime = now.Format(onePasswordTimestampFormat)
-		}
-
-		payloadBytes, err := json.Marshal(&payload)
-		if err != nil {
-			return nil, fmt.Errorf("could not encode payload: %v", err)
-		}
-
-		signinRequest, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/signinattempts", p.apiURL), bytes.NewBuffer(payloadBytes))
-		if err != nil {
-			return nil, fmt.Errorf("could not create signin request: %v", err)
-		}
-
-		signinRequest.Header.Set("Content-Type", "application/json")
-		signinRequest.Header.Set("Authorization", "Bearer "+p.apiToken)
-
-		signinResponse, err := p.httpClient.Do(signinRequest)
-		if err != nil {
-			return nil, fmt.Errorf("could not fetch signins: %v", err)
-		}
-
-		if signinResponse.StatusCode > 399 {
-			_ = signinResponse.Body.Close()
-			return nil, fmt.Errorf("returned status code: %d", signinResponse.StatusCode)
-		}
-
-		signinsBody, err := io.ReadAll(signinResponse.Body)
-		_ = signinResponse.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("could not read signin response body: %v", err)
-		}
+func (e *Event) UnmarshalJSON(data []byte) error {
+	type plain Event
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
 
-		var resp eventResponse
+	e.Raw = append(json.RawMessage(nil), data...)
 
-		if err := json.Unmarshal(signinsBody, &resp); err != nil {
-			return nil, fmt.Errorf("could not decode usage response: %v", err)
-		}
+	return nil
+}
 
-		if resp.Error.Message != "" {
-			return nil, fmt.Errorf("returned error: %v", resp.Error.Message)
-		}
+func (e Event) RawJSON() json.RawMessage {
+	return e.Raw
+}
 
-		hasMore = resp.HasMore
-		cursor = resp.Cursor
+func (e Event) EventID() string {
+	return e.UUID
+}
 
-		items = append(items, resp.Items...)
-	}
+func (e Event) EventTime() (time.Time, error) {
+	return ParseTimestamp(e.Timestamp)
+}
 
-	p.Logger.WithField("total", len(items)).Debug("retrieved signin events")
+func (e *Event) IsOK() bool {
+	return strings.Contains(strings.ToLower(e.Type), "_ok")
+}
 
-	return items, nil
+func (p *OnePassword) GetSigninEvents(ctx context.Context, lookback time.Duration, cursor string) ([]Event, string, error) {
+	return collect[Event](ctx, p, StreamSignins, lookback, cursor)
 }
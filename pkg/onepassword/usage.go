@@ -1,23 +1,11 @@
 package onepassword
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
 	"time"
 )
 
-type usageResponse struct {
-	Cursor  string `json:"cursor"`
-	HasMore bool   `json:"has_more"`
-	Items   []Item `json:"items"`
-	Error   struct {
-		Message string `json:"Message"`
-	} `json:"Error"`
-}
-
 type User struct {
 	UUID  string `json:"uuid"`
 	Name  string `json:"name"`
@@ -52,79 +40,34 @@ type Item struct {
 	Client      Client   `json:"client"`
 	Location    Location `json:"location"`
 	Action      string   `json:"action"`
-}
-
-func (p *OnePassword) GetUsage(lookback time.Duration) ([]Item, error) {
-	items := make([]Item, 0)
-
-	now := time.Now().UTC()
-	startTime := now.Add(-lookback)
-
-	round := 0
-	hasMore := true
-	cursor := ""
-
-	for hasMore {
-		round++
-		p.Logger.WithField("round", round).Debug("fetching usage events")
-
-		payload := eventRequest{}
-		if cursor != "" {
-			payload.Cursor = cursor
-		} else {
-			payload.Limit = maxFetch
-			payload.StartTime = startTime.Format(onePasswordTimestampFormat)
-			payload.EndTime = now.Format(onePasswordTimestampFormat)
-		}
-
-		payloadBytes, err := json.Marshal(&payload)
-		if err != nil {
-			return nil, fmt.Errorf("could not encode payload: %v", err)
-		}
-
-		p.Logger.Debugf("%s", payloadBytes)
-
-		usagesRequest, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/itemusages", p.apiURL), bytes.NewBuffer(payloadBytes))
-		if err != nil {
-			return nil, fmt.Errorf("could not create usage request: %v", err)
-		}
 
-		usagesRequest.Header.Set("Content-Type", "application/json")
-		usagesRequest.Header.Set("Authorization", "Bearer "+p.apiToken)
-
-		usagesResponse, usagesError := p.httpClient.Do(usagesRequest)
-		if usagesError != nil {
-			return nil, fmt.Errorf("could not fetch usage: %v", err)
-		}
-
-		if usagesResponse.StatusCode > 399 {
-			_ = usagesResponse.Body.Close()
-			return nil, fmt.Errorf("returned status code: %d", usagesResponse.StatusCode)
-		}
-
-		usagesBody, err := io.ReadAll(usagesResponse.Body)
-		_ = usagesResponse.Body.Close()
-		if err != nil {
-			return nil, fmt.Errorf("could not read usage: %v", err)
-		}
+	// Raw holds the event exactly as it was returned by the Events API
+	Raw json.RawMessage `json:"-"`
+}
 
-		var resp usageResponse
+func (i *Item) UnmarshalJSON(data []byte) error {
+	type plain Item
+	if err := json.Unmarshal(data, (*plain)(i)); err != nil {
+		return err
+	}
 
-		if err := json.Unmarshal(usagesBody, &resp); err != nil {
-			return nil, fmt.Errorf("could not decode usage response: %v", err)
-		}
+	i.Raw = append(json.RawMessage(nil), data...)
 
-		if resp.Error.Message != "" {
-			return nil, fmt.Errorf("returned error: %v", resp.Error.Message)
-		}
+	return nil
+}
 
-		hasMore = resp.HasMore
-		cursor = resp.Cursor
+func (i Item) RawJSON() json.RawMessage {
+	return i.Raw
+}
 
-		items = append(items, resp.Items...)
-	}
+func (i Item) EventID() string {
+	return i.UUID
+}
 
-	p.Logger.WithField("total", len(items)).Debug("retrieved usage events")
+func (i Item) EventTime() (time.Time, error) {
+	return ParseTimestamp(i.Timestamp)
+}
 
-	return items, nil
+func (p *OnePassword) GetUsage(ctx context.Context, lookback time.Duration, cursor string) ([]Item, string, error) {
+	return collect[Item](ctx, p, StreamUsage, lookback, cursor)
 }
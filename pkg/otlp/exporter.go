@@ -0,0 +1,298 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	logsPath = "/v1/logs"
+
+	scopeName   = "github.com/hazcod/one2sen"
+	serviceName = "one2sen"
+
+	// stay below the default 4MiB message limit of gRPC servers
+	defaultMaxBatchBytes = 4 * 1000 * 1000
+)
+
+// Options configure the OTLP logs sink.
+type Options struct {
+	// grpc or http
+	Protocol string
+	// host:port for grpc, a URL such as http://collector:4318 for http
+	Endpoint string
+	// send without TLS, only used for grpc as the http scheme already says so
+	Insecure bool
+	Gzip     bool
+	// extra headers or gRPC metadata, e.g. for authentication
+	Headers map[string]secret.Func
+
+	MaxBatchBytes int
+	Retry         sink.Retry
+}
+
+// Exporter sends logs as OTLP log records to an OpenTelemetry collector over gRPC or HTTP.
+// Every batch becomes a resource identifying the 1Password account, with one log record per event.
+type Exporter struct {
+	logger *logrus.Logger
+	opts   Options
+
+	httpClient *http.Client
+	conn       *grpc.ClientConn
+	client     collogspb.LogsServiceClient
+}
+
+func New(logger *logrus.Logger, opts Options) (*Exporter, error) {
+	if opts.Endpoint == "" {
+		return nil, errors.New("no otlp endpoint provided")
+	}
+
+	if opts.Protocol == "" {
+		opts.Protocol = ProtocolGRPC
+	}
+
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = defaultMaxBatchBytes
+	}
+
+	exporter := &Exporter{
+		logger: logger,
+		opts:   opts,
+	}
+
+	switch opts.Protocol {
+	case ProtocolGRPC:
+		creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		if opts.Insecure {
+			creds = insecure.NewCredentials()
+		}
+
+		conn, err := grpc.NewClient(opts.Endpoint, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			return nil, fmt.Errorf("could not create otlp grpc client: %v", err)
+		}
+
+		exporter.conn = conn
+		exporter.client = collogspb.NewLogsServiceClient(conn)
+
+	case ProtocolHTTP:
+		opts.Endpoint = strings.TrimSuffix(opts.Endpoint, "/")
+		if !strings.HasSuffix(opts.Endpoint, logsPath) {
+			opts.Endpoint += logsPath
+		}
+
+		exporter.opts.Endpoint = opts.Endpoint
+		exporter.httpClient = utils.NewLogHttpClient(logger)
+
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol '%s', use %s or %s", opts.Protocol, ProtocolGRPC, ProtocolHTTP)
+	}
+
+	return exporter, nil
+}
+
+func (e *Exporter) Name() string {
+	return "otlp"
+}
+
+func (e *Exporter) Close() error {
+	if e.conn == nil {
+		return nil
+	}
+
+	return e.conn.Close()
+}
+
+// Send exports every log of batch, split into requests of at most MaxBatchBytes.
+func (e *Exporter) Send(ctx context.Context, batch sink.Batch) error {
+	observed := time.Now()
+	records := make([]*logspb.LogRecord, 0, len(batch.Logs))
+
+	for _, log := range batch.Logs {
+		logRecord, err := toLogRecord(batch.Stream, log, observed)
+		if err != nil {
+			return err
+		}
+
+		records = append(records, logRecord)
+	}
+
+	for _, chunk := range sink.Chunk(records, e.opts.MaxBatchBytes, func(r *logspb.LogRecord) int { return proto.Size(r) }) {
+		req := e.request(batch, chunk)
+
+		if err := e.opts.Retry.Do(ctx, e.logger, "otlp", func() error { return e.export(ctx, req) }); err != nil {
+			return err
+		}
+	}
+
+	e.logger.WithField("module", "otlp").WithField("stream", batch.Stream).
+		WithField("total", len(batch.Logs)).Debug("exported logs")
+
+	return nil
+}
+
+func (e *Exporter) request(batch sink.Batch, records []*logspb.LogRecord) *collogspb.ExportLogsServiceRequest {
+	resource := attributes{}
+	resource.string("service.name", serviceName)
+	resource.string("onepassword.account", batch.Account)
+
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: resource},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      &commonpb.InstrumentationScope{Name: scopeName},
+				LogRecords: records,
+			}},
+		}},
+	}
+}
+
+func (e *Exporter) export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	headers := make(map[string]string, len(e.opts.Headers))
+	for name, value := range e.opts.Headers {
+		resolved, err := value(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get otlp header %s: %v", name, err)
+		}
+
+		headers[name] = resolved
+	}
+
+	var partial *collogspb.ExportLogsPartialSuccess
+	var err error
+
+	if e.client != nil {
+		partial, err = e.exportGRPC(ctx, req, headers)
+	} else {
+		partial, err = e.exportHTTP(ctx, req, headers)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// rejected records must not be retried, they would be rejected again
+	if partial.GetRejectedLogRecords() > 0 {
+		e.logger.WithField("module", "otlp").WithField("rejected", partial.GetRejectedLogRecords()).
+			WithField("message", partial.GetErrorMessage()).Warn("collector rejected log records")
+	}
+
+	return nil
+}
+
+func (e *Exporter) exportGRPC(ctx context.Context, req *collogspb.ExportLogsServiceRequest, headers map[string]string) (*collogspb.ExportLogsPartialSuccess, error) {
+	if len(headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(headers))
+	}
+
+	var callOpts []grpc.CallOption
+	if e.opts.Gzip {
+		callOpts = append(callOpts, grpc.UseCompressor(grpcgzip.Name))
+	}
+
+	resp, err := e.client.Export(ctx, req, callOpts...)
+	if err == nil {
+		return resp.GetPartialSuccess(), nil
+	}
+
+	// the status codes which the OTLP specification marks as retryable
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted,
+		codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return nil, fmt.Errorf("could not export logs: %w", err)
+	default:
+		return nil, sink.Permanent(fmt.Errorf("could not export logs: %w", err))
+	}
+}
+
+func (e *Exporter) exportHTTP(ctx context.Context, req *collogspb.ExportLogsServiceRequest, headers map[string]string) (*collogspb.ExportLogsPartialSuccess, error) {
+	payload, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode logs: %v", err)
+	}
+
+	if e.opts.Gzip {
+		var compressed bytes.Buffer
+
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(payload); err != nil {
+			return nil, fmt.Errorf("could not compress logs: %v", err)
+		}
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("could not compress logs: %v", err)
+		}
+
+		payload = compressed.Bytes()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.opts.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("could not create otlp request: %v", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if e.opts.Gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+
+	for name, value := range headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := e.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach otlp collector: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read otlp response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("otlp collector rejected the logs: %w", sink.NewStatusError(resp, body))
+
+		// the status codes which the OTLP specification marks as retryable, unlike other server errors
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return nil, err
+		default:
+			return nil, sink.Permanent(err)
+		}
+	}
+
+	var exportResp collogspb.ExportLogsServiceResponse
+	if err := proto.Unmarshal(body, &exportResp); err != nil {
+		return nil, fmt.Errorf("could not decode otlp response: %v", err)
+	}
+
+	return exportResp.GetPartialSuccess(), nil
+}
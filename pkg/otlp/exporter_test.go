@@ -0,0 +1,158 @@
+package otlp
+
+import (
+	"context"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+	requests chan *collogspb.ExportLogsServiceRequest
+}
+
+func (c *fakeCollector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	if md, _ := metadata.FromIncomingContext(ctx); len(md.Get("api-key")) == 0 || md.Get("api-key")[0] != "key" {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	c.requests <- req
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func TestExporter_Send(t *testing.T) {
+	collector := &fakeCollector{requests: make(chan *collogspb.ExportLogsServiceRequest, 2)}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(server, collector)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Api-Key") != "key" || err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var req collogspb.ExportLogsServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		collector.requests <- &req
+	}))
+	defer httpServer.Close()
+
+	signins, err := onepassword.ConvertSigninToFlatMap(nil, []onepassword.Event{
+		{UUID: "e1", Timestamp: "2024-01-02T03:04:05Z", Type: "mfa_failed", Client: onepassword.Client{IPAddress: "192.0.2.1"},
+			TargetUser: onepassword.TargetUser{Email: "jane@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []Options{
+		{Protocol: ProtocolGRPC, Endpoint: listener.Addr().String(), Insecure: true},
+		{Protocol: ProtocolHTTP, Endpoint: httpServer.URL},
+	} {
+		opts.Headers = map[string]secret.Func{"api-key": secret.Static("key")}
+
+		exporter, err := New(logrus.New(), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := exporter.Send(context.Background(), sink.Batch{Account: "eu", Stream: onepassword.StreamSignins, Logs: signins}); err != nil {
+			t.Fatalf("%s: %v", opts.Protocol, err)
+		}
+
+		_ = exporter.Close()
+
+		req := <-collector.requests
+		resource := req.ResourceLogs[0].Resource.Attributes
+		logRecord := req.ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+
+		attrs := map[string]string{}
+		for _, attr := range logRecord.Attributes {
+			attrs[attr.Key] = attr.Value.GetStringValue()
+		}
+
+		if resource[1].Key != "onepassword.account" || resource[1].Value.GetStringValue() != "eu" {
+			t.Fatalf("%s: unexpected resource: %v", opts.Protocol, resource)
+		}
+
+		eventTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		if logRecord.TimeUnixNano != uint64(eventTime.UnixNano()) || logRecord.EventName != "1password.signinattempts" {
+			t.Fatalf("%s: unexpected log record: %v", opts.Protocol, logRecord)
+		}
+
+		if attrs["user.email"] != "jane@example.com" || attrs["client.address"] != "192.0.2.1" || attrs["onepassword.outcome"] != "failure" {
+			t.Fatalf("%s: unexpected attributes: %v", opts.Protocol, attrs)
+		}
+	}
+}
+
+func TestExporter_SendHTTPRetry(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int
+	}{
+		{http.StatusServiceUnavailable, 2},
+		{http.StatusTooManyRequests, 2},
+		// only the status codes the OTLP specification marks as retryable are sent again
+		{http.StatusInternalServerError, 1},
+		{http.StatusBadRequest, 1},
+	}
+
+	signins, err := onepassword.ConvertSigninToFlatMap(nil, []onepassword.Event{{UUID: "e1", Timestamp: "2024-01-02T03:04:05Z"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		requests := 0
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.WriteHeader(tt.status)
+			}
+		}))
+
+		exporter, err := New(logrus.New(), Options{Protocol: ProtocolHTTP, Endpoint: server.URL, Retry: sink.Retry{BaseDelay: time.Millisecond}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = exporter.Send(context.Background(), sink.Batch{Account: "eu", Stream: onepassword.StreamSignins, Logs: signins})
+		if (tt.requests == 1) != (err != nil) {
+			t.Errorf("%d: unexpected error: %v", tt.status, err)
+		}
+
+		if requests != tt.requests {
+			t.Errorf("%d: expected %d requests, got %d", tt.status, tt.requests, requests)
+		}
+
+		_ = exporter.Close()
+		server.Close()
+	}
+}
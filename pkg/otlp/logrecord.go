@@ -0,0 +1,123 @@
+package otlp
+
+import (
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"sort"
+	"time"
+)
+
+// toLogRecord maps a converted 1Password log onto an OTLP log record with semantic convention attributes.
+// The normalized log becomes the body, so no field is lost.
+func toLogRecord(stream string, log record.Record, observed time.Time) (*logspb.LogRecord, error) {
+	normalized, err := log.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	// the account is a resource attribute and the raw event would duplicate the body
+	delete(normalized, onepassword.AccountColumn)
+	delete(normalized, onepassword.RawEventColumn)
+
+	logRecord := &logspb.LogRecord{
+		ObservedTimeUnixNano: uint64(observed.UnixNano()),
+		SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		SeverityText:         "INFO",
+		EventName:            "1password." + stream,
+		Body:                 anyValue(normalized),
+	}
+
+	if timeGenerated := record.LookupString(normalized, "TimeGenerated"); timeGenerated != "" {
+		eventTime, err := onepassword.ParseTimeGenerated(timeGenerated)
+		if err != nil {
+			return nil, err
+		}
+
+		logRecord.TimeUnixNano = uint64(eventTime.UnixNano())
+	}
+
+	attrs := attributes{}
+	attrs.string("event.name", logRecord.EventName)
+	attrs.string("log.record.uid", record.Lookup(normalized, "UUID", "Data.UUID"))
+	attrs.string("user.id", record.Lookup(normalized, "ActorUUID", "Data.ActorUUID"))
+	attrs.string("user.name", record.Lookup(normalized, "ActorName", "Data.ActorName"))
+	attrs.string("user.email", record.Lookup(normalized, "ActorEmail", "Data.ActorEmail"))
+	attrs.string("client.address", record.Lookup(normalized, "IPAddress", "Client.ip_address", "SessionIP", "Data.SessionIP"))
+	attrs.string("geo.country.iso_code", record.Lookup(normalized, "Country", "Data.Country"))
+	attrs.string("geo.locality.name", record.Lookup(normalized, "City", "Data.City"))
+	attrs.string("onepassword.action", record.Lookup(normalized, "Action", "Data.Action", "EventType", "Data.EventType"))
+
+	lat, latOK := record.Lookup(normalized, "Latitude", "Location.latitude").(float64)
+	lon, lonOK := record.Lookup(normalized, "Longitude", "Location.longitude").(float64)
+	if latOK && lonOK && (lat != 0 || lon != 0) {
+		attrs.double("geo.location.lat", lat)
+		attrs.double("geo.location.lon", lon)
+	}
+
+	if ok, isBool := record.Lookup(normalized, "OK", "Data.OK").(bool); isBool {
+		outcome := "failure"
+		if ok {
+			outcome = "success"
+		} else {
+			logRecord.SeverityNumber = logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+			logRecord.SeverityText = "WARN"
+		}
+
+		attrs.string("onepassword.outcome", outcome)
+	}
+
+	logRecord.Attributes = attrs
+
+	return logRecord, nil
+}
+
+// attributes is a list of OTLP attributes which leaves out empty values.
+type attributes []*commonpb.KeyValue
+
+func (a *attributes) string(key string, value any) {
+	if s, ok := value.(string); ok && s != "" {
+		*a = append(*a, &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}})
+	}
+}
+
+func (a *attributes) double(key string, value float64) {
+	*a = append(*a, &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}}})
+}
+
+// anyValue converts a decoded JSON value to an OTLP value, objects become key value lists with sorted keys.
+func anyValue(value any) *commonpb.AnyValue {
+	switch v := value.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case float64:
+		if v == float64(int64(v)) {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case []any:
+		values := make([]*commonpb.AnyValue, len(v))
+		for i, item := range v {
+			values[i] = anyValue(item)
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		values := make([]*commonpb.KeyValue, len(keys))
+		for i, key := range keys {
+			values[i] = &commonpb.KeyValue{Key: key, Value: anyValue(v[key])}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: values}}}
+	default:
+		// null
+		return &commonpb.AnyValue{}
+	}
+}
@@ -0,0 +1,56 @@
+package record
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Record is a single converted event, mapping column names to values that keep their native JSON type.
+// Nested objects stay objects, so they arrive as real dynamic values instead of JSON encoded strings.
+type Record map[string]any
+
+// Normalize returns the record with every nested struct converted to plain maps, as it would be decoded from JSON.
+func (r Record) Normalize() (map[string]any, error) {
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode record: %v", err)
+	}
+
+	var normalized map[string]any
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return nil, fmt.Errorf("could not decode record: %v", err)
+	}
+
+	return normalized, nil
+}
+
+// Lookup returns the first non-empty value out of the dotted paths into a normalized record.
+// This allows reading a field regardless of the table layout, e.g. Lookup(log, "ActorEmail", "Data.ActorEmail").
+func Lookup(normalized map[string]any, paths ...string) any {
+	for _, path := range paths {
+		var value any = normalized
+
+		for _, key := range strings.Split(path, ".") {
+			nested, ok := value.(map[string]any)
+			if !ok {
+				value = nil
+				break
+			}
+
+			value = nested[key]
+		}
+
+		if value != nil && value != "" {
+			return value
+		}
+	}
+
+	return nil
+}
+
+// LookupString is Lookup for string values, returning an empty string when none was found.
+func LookupString(normalized map[string]any, paths ...string) string {
+	value, _ := Lookup(normalized, paths...).(string)
+	return value
+}
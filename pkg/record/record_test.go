@@ -0,0 +1,50 @@
+package record
+
+import (
+	"testing"
+)
+
+type client struct {
+	IPAddress string `json:"IPAddress"`
+}
+
+func TestLookup(t *testing.T) {
+	// the same event in the flat layout and with the event nested under Data
+	flat, err := Record{"ActorEmail": "jane@example.com", "Client": client{IPAddress: "192.0.2.1"}, "Country": ""}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nested, err := Record{"Data": map[string]any{"ActorEmail": "jane@example.com", "Client": client{IPAddress: "192.0.2.1"}, "Country": ""}}.Normalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := flat["Client"].(map[string]any); !ok {
+		t.Fatalf("expected the struct to be normalized to a map: %T", flat["Client"])
+	}
+
+	for name, log := range map[string]map[string]any{"flat": flat, "nested": nested} {
+		if got := LookupString(log, "ActorEmail", "Data.ActorEmail"); got != "jane@example.com" {
+			t.Errorf("%s: unexpected email %q", name, got)
+		}
+
+		if got := LookupString(log, "Client.IPAddress", "Data.Client.IPAddress"); got != "192.0.2.1" {
+			t.Errorf("%s: unexpected ip address %q", name, got)
+		}
+
+		// an empty value falls through to the next path
+		if got := LookupString(log, "Country", "Data.Country", "ActorEmail", "Data.ActorEmail"); got != "jane@example.com" {
+			t.Errorf("%s: expected the empty country to be skipped, got %q", name, got)
+		}
+
+		// a path through a value which is not an object is not found
+		if got := Lookup(log, "ActorEmail.Domain", "Data.ActorEmail.Domain", "Missing"); got != nil {
+			t.Errorf("%s: expected no value, got %v", name, got)
+		}
+	}
+
+	if got := LookupString(map[string]any{"Count": float64(1)}, "Count"); got != "" {
+		t.Errorf("expected an empty string for a number, got %q", got)
+	}
+}
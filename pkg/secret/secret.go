@@ -0,0 +1,263 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileScheme = "file://"
+	envScheme  = "env://"
+	opScheme   = "op://"
+
+	defaultRefresh = time.Minute
+
+	connectTimeout = time.Second * 30
+)
+
+// Func returns the current value of a secret.
+type Func func(ctx context.Context) (string, error)
+
+// Static returns a Func which always returns value as is.
+func Static(value string) Func {
+	return func(context.Context) (string, error) {
+		return value, nil
+	}
+}
+
+// Options configure how secret references are resolved.
+type Options struct {
+	// 1Password Connect server used for op:// references
+	ConnectURL string
+	// token of the Connect server, which may itself be a file:// or env:// reference
+	ConnectToken string
+	// how long op:// values are cached before they are fetched again
+	Refresh time.Duration
+}
+
+type cachedValue struct {
+	value     string
+	fetchedAt time.Time
+}
+
+// Resolver resolves secret references. Plain values are returned as is, file:// and env:// references are
+// read on every call and op:// references are fetched from 1Password Connect and cached for the refresh interval,
+// so rotated secrets are picked up without a restart.
+type Resolver struct {
+	opts       Options
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]cachedValue
+}
+
+func NewResolver(opts Options) *Resolver {
+	if opts.Refresh <= 0 {
+		opts.Refresh = defaultRefresh
+	}
+
+	opts.ConnectURL = strings.TrimSuffix(opts.ConnectURL, "/")
+
+	return &Resolver{
+		opts:       opts,
+		httpClient: &http.Client{Timeout: connectTimeout},
+		cache:      make(map[string]cachedValue),
+	}
+}
+
+// Func returns a Func resolving ref every time it is called.
+func (r *Resolver) Func(ref string) Func {
+	return func(ctx context.Context) (string, error) {
+		return r.Resolve(ctx, ref)
+	}
+}
+
+// Resolve returns the current value of the secret referenced by ref.
+func (r *Resolver) Resolve(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, fileScheme):
+		return readFile(strings.TrimPrefix(ref, fileScheme))
+
+	case strings.HasPrefix(ref, envScheme):
+		name := strings.TrimPrefix(ref, envScheme)
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable '%s' is not set", name)
+		}
+
+		return value, nil
+
+	case strings.HasPrefix(ref, opScheme):
+		return r.resolveConnect(ctx, ref)
+
+	default:
+		return ref, nil
+	}
+}
+
+func readFile(path string) (string, error) {
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read secret file '%s': %v", path, err)
+	}
+
+	// secret files are often written with a trailing newline
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+func (r *Resolver) resolveConnect(ctx context.Context, ref string) (string, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[ref]
+	r.mu.Unlock()
+
+	if ok && time.Since(cached.fetchedAt) < r.opts.Refresh {
+		return cached.value, nil
+	}
+
+	value, err := r.fetchConnect(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	r.cache[ref] = cachedValue{value: value, fetchedAt: time.Now()}
+	r.mu.Unlock()
+
+	return value, nil
+}
+
+type connectVault struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type connectItem struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Sections []struct {
+		ID    string `json:"id"`
+		Label string `json:"label"`
+	} `json:"sections"`
+	Fields []struct {
+		ID      string `json:"id"`
+		Label   string `json:"label"`
+		Value   string `json:"value"`
+		Section *struct {
+			ID string `json:"id"`
+		} `json:"section"`
+	} `json:"fields"`
+}
+
+// fetchConnect resolves op://vault/item/[section/]field, where vault and item are either names or ids.
+func (r *Resolver) fetchConnect(ctx context.Context, ref string) (string, error) {
+	if r.opts.ConnectURL == "" {
+		return "", fmt.Errorf("no 1Password Connect server configured to resolve '%s'", ref)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(ref, opScheme), "/")
+	if len(parts) != 3 && len(parts) != 4 {
+		return "", fmt.Errorf("invalid secret reference '%s', use op://vault/item/[section/]field", ref)
+	}
+
+	vaultName, itemName, fieldName := parts[0], parts[1], parts[len(parts)-1]
+	sectionName := ""
+	if len(parts) == 4 {
+		sectionName = parts[2]
+	}
+
+	var vaults []connectVault
+	if err := r.getConnect(ctx, "/v1/vaults?filter="+url.QueryEscape(fmt.Sprintf("name eq %q", vaultName)), &vaults); err != nil {
+		return "", err
+	}
+
+	vaultID := vaultName
+	if len(vaults) > 0 {
+		vaultID = vaults[0].ID
+	}
+
+	var items []connectItem
+	if err := r.getConnect(ctx, fmt.Sprintf("/v1/vaults/%s/items?filter=%s", url.PathEscape(vaultID), url.QueryEscape(fmt.Sprintf("title eq %q", itemName))), &items); err != nil {
+		return "", err
+	}
+
+	itemID := itemName
+	if len(items) > 0 {
+		itemID = items[0].ID
+	}
+
+	var item connectItem
+	if err := r.getConnect(ctx, fmt.Sprintf("/v1/vaults/%s/items/%s", url.PathEscape(vaultID), url.PathEscape(itemID)), &item); err != nil {
+		return "", err
+	}
+
+	sectionID := ""
+	for _, section := range item.Sections {
+		if section.Label == sectionName || section.ID == sectionName {
+			sectionID = section.ID
+		}
+	}
+
+	for _, field := range item.Fields {
+		if field.Label != fieldName && field.ID != fieldName {
+			continue
+		}
+
+		if sectionName != "" && (field.Section == nil || field.Section.ID != sectionID) {
+			continue
+		}
+
+		return field.Value, nil
+	}
+
+	return "", fmt.Errorf("field not found for secret reference '%s'", ref)
+}
+
+func (r *Resolver) getConnect(ctx context.Context, path string, out any) error {
+	// the Connect token can only come from a file, the environment or the configuration itself
+	if strings.HasPrefix(r.opts.ConnectToken, opScheme) {
+		return errors.New("the 1Password Connect token cannot be an op:// reference")
+	}
+
+	token, err := r.Resolve(ctx, r.opts.ConnectToken)
+	if err != nil {
+		return fmt.Errorf("could not resolve 1Password Connect token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.opts.ConnectURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("could not create 1Password Connect request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach 1Password Connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("1Password Connect returned status %d for %s", resp.StatusCode, req.URL.Path)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read 1Password Connect response: %v", err)
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("could not decode 1Password Connect response: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package secret
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ONE2SEN_TEST_SECRET", "from-env")
+
+	connect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer connect-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/vaults":
+			_, _ = w.Write([]byte(`[{"id":"v1","name":"Infra"}]`))
+		case "/v1/vaults/v1/items":
+			_, _ = w.Write([]byte(`[{"id":"i1","title":"Events"}]`))
+		case "/v1/vaults/v1/items/i1":
+			_, _ = w.Write([]byte(`{"id":"i1","sections":[{"id":"s1","label":"azure"}],"fields":[
+				{"id":"f1","label":"credential","value":"from-connect"},
+				{"id":"f2","label":"credential","value":"from-section","section":{"id":"s1"}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer connect.Close()
+
+	resolver := NewResolver(Options{ConnectURL: connect.URL, ConnectToken: "env://ONE2SEN_TEST_CONNECT"})
+	t.Setenv("ONE2SEN_TEST_CONNECT", "connect-token")
+
+	tests := map[string]string{
+		"plain":                              "plain",
+		"file://" + path:                     "from-file",
+		"env://ONE2SEN_TEST_SECRET":          "from-env",
+		"op://Infra/Events/credential":       "from-connect",
+		"op://Infra/Events/azure/credential": "from-section",
+	}
+
+	for ref, expected := range tests {
+		value, err := resolver.Resolve(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("%s: %v", ref, err)
+		}
+
+		if value != expected {
+			t.Fatalf("%s resolved to '%s', expected '%s'", ref, value, expected)
+		}
+	}
+
+	if _, err := resolver.Resolve(context.Background(), "op://Infra/Events/missing"); err == nil {
+		t.Fatal("expected an error for a missing field")
+	}
+}
@@ -0,0 +1,159 @@
+package sentinel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"os"
+	"sync"
+)
+
+// AuthMethod selects how to authenticate against Azure.
+type AuthMethod string
+
+const (
+	AuthClientSecret     AuthMethod = "client_secret"
+	AuthCertificate      AuthMethod = "certificate"
+	AuthManagedIdentity  AuthMethod = "managed_identity"
+	AuthWorkloadIdentity AuthMethod = "workload_identity"
+	AuthDefault          AuthMethod = "default"
+)
+
+// newTokenCredential creates the Azure credential for the selected authentication method.
+func newTokenCredential(creds Credentials) (azcore.TokenCredential, error) {
+	switch creds.AuthMethod {
+	case AuthClientSecret, "":
+		if creds.ClientID == "" || creds.ClientSecret == nil {
+			return nil, errors.New("client secret authentication needs a client id and secret")
+		}
+
+		return newRotatingCredential(func(ctx context.Context) ([]byte, builder, error) {
+			clientSecret, err := creds.ClientSecret(ctx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not get client secret: %v", err)
+			}
+
+			return []byte(clientSecret), func() (azcore.TokenCredential, error) {
+				return azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, clientSecret, nil)
+			}, nil
+		})
+
+	case AuthCertificate:
+		if creds.ClientID == "" || creds.CertificatePath == "" {
+			return nil, errors.New("certificate authentication needs a client id and certificate")
+		}
+
+		return newRotatingCredential(func(ctx context.Context) ([]byte, builder, error) {
+			certData, err := os.ReadFile(creds.CertificatePath)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not read certificate '%s': %v", creds.CertificatePath, err)
+			}
+
+			var password []byte
+			if creds.CertificatePassword != nil {
+				value, err := creds.CertificatePassword(ctx)
+				if err != nil {
+					return nil, nil, fmt.Errorf("could not get certificate password: %v", err)
+				}
+
+				password = []byte(value)
+			}
+
+			return append(append([]byte{}, certData...), password...), func() (azcore.TokenCredential, error) {
+				// handles both PEM and PFX, the password is only needed for encrypted keys
+				certs, key, err := azidentity.ParseCertificates(certData, password)
+				if err != nil {
+					return nil, fmt.Errorf("could not parse certificate '%s': %v", creds.CertificatePath, err)
+				}
+
+				return azidentity.NewClientCertificateCredential(creds.TenantID, creds.ClientID, certs, key, nil)
+			}, nil
+		})
+
+	case AuthManagedIdentity:
+		opts := azidentity.ManagedIdentityCredentialOptions{}
+
+		// a client id selects a user-assigned identity, otherwise the system-assigned identity is used
+		if creds.ClientID != "" {
+			opts.ID = azidentity.ClientID(creds.ClientID)
+		}
+
+		return azidentity.NewManagedIdentityCredential(&opts)
+
+	case AuthWorkloadIdentity:
+		// empty values fall back to the environment variables set by the AKS workload identity webhook
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			TenantID:      creds.TenantID,
+			ClientID:      creds.ClientID,
+			TokenFilePath: creds.FederatedTokenFile,
+		})
+
+	case AuthDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			TenantID: creds.TenantID,
+		})
+
+	default:
+		return nil, fmt.Errorf("unknown authentication method '%s'", creds.AuthMethod)
+	}
+}
+
+// builder creates a credential out of freshly loaded secret material.
+type builder func() (azcore.TokenCredential, error)
+
+// rotatingCredential rebuilds its credential whenever the secret material it was built from changes.
+// Tokens are cached by the Azure SDK, so the material is only loaded again when a new token is needed.
+type rotatingCredential struct {
+	load func(ctx context.Context) ([]byte, builder, error)
+
+	mu         sync.Mutex
+	material   []byte
+	credential azcore.TokenCredential
+}
+
+func newRotatingCredential(load func(ctx context.Context) ([]byte, builder, error)) (*rotatingCredential, error) {
+	r := rotatingCredential{load: load}
+
+	// fail early on a secret which cannot be loaded
+	if _, err := r.current(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func (r *rotatingCredential) current(ctx context.Context) (azcore.TokenCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	material, build, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.credential != nil && bytes.Equal(material, r.material) {
+		return r.credential, nil
+	}
+
+	credential, err := build()
+	if err != nil {
+		return nil, err
+	}
+
+	r.material, r.credential = material, credential
+
+	return credential, nil
+}
+
+func (r *rotatingCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	credential, err := r.current(ctx)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+
+	return credential.GetToken(ctx, opts)
+}
@@ -0,0 +1,217 @@
+package sentinel
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed PEM certificate with its key to a temporary file.
+func writeCertificate(t *testing.T, path string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "one2sen"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})...,
+	)
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewTokenCredential(t *testing.T) {
+	dir := t.TempDir()
+
+	certPath := filepath.Join(dir, "cert.pem")
+	writeCertificate(t, certPath)
+
+	tokenPath := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenPath, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		creds   Credentials
+		want    func(azcore.TokenCredential) bool
+		wantErr string
+	}{
+		"client secret": {
+			creds: Credentials{AuthMethod: AuthClientSecret, TenantID: "tenant", ClientID: "client", ClientSecret: secret.Static("secret")},
+			want:  isRotating,
+		},
+		"client secret by default": {
+			creds: Credentials{TenantID: "tenant", ClientID: "client", ClientSecret: secret.Static("secret")},
+			want:  isRotating,
+		},
+		"client secret without client id": {
+			creds:   Credentials{AuthMethod: AuthClientSecret, TenantID: "tenant", ClientSecret: secret.Static("secret")},
+			wantErr: "client secret authentication needs a client id and secret",
+		},
+		"client secret without secret": {
+			creds:   Credentials{AuthMethod: AuthClientSecret, TenantID: "tenant", ClientID: "client"},
+			wantErr: "client secret authentication needs a client id and secret",
+		},
+		"certificate": {
+			creds: Credentials{AuthMethod: AuthCertificate, TenantID: "tenant", ClientID: "client", CertificatePath: certPath},
+			want:  isRotating,
+		},
+		"certificate without client id": {
+			creds:   Credentials{AuthMethod: AuthCertificate, TenantID: "tenant", CertificatePath: certPath},
+			wantErr: "certificate authentication needs a client id and certificate",
+		},
+		"certificate without path": {
+			creds:   Credentials{AuthMethod: AuthCertificate, TenantID: "tenant", ClientID: "client"},
+			wantErr: "certificate authentication needs a client id and certificate",
+		},
+		"certificate which does not exist": {
+			creds:   Credentials{AuthMethod: AuthCertificate, TenantID: "tenant", ClientID: "client", CertificatePath: filepath.Join(dir, "missing.pem")},
+			wantErr: "could not read certificate",
+		},
+		"certificate which is not a certificate": {
+			creds:   Credentials{AuthMethod: AuthCertificate, TenantID: "tenant", ClientID: "client", CertificatePath: tokenPath},
+			wantErr: "could not parse certificate",
+		},
+		"managed identity": {
+			creds: Credentials{AuthMethod: AuthManagedIdentity, ClientID: "client"},
+			want: func(c azcore.TokenCredential) bool {
+				_, ok := c.(*azidentity.ManagedIdentityCredential)
+				return ok
+			},
+		},
+		"workload identity": {
+			creds: Credentials{AuthMethod: AuthWorkloadIdentity, TenantID: "tenant", ClientID: "client", FederatedTokenFile: tokenPath},
+			want: func(c azcore.TokenCredential) bool {
+				_, ok := c.(*azidentity.WorkloadIdentityCredential)
+				return ok
+			},
+		},
+		"default": {
+			creds: Credentials{AuthMethod: AuthDefault, TenantID: "tenant"},
+			want: func(c azcore.TokenCredential) bool {
+				_, ok := c.(*azidentity.DefaultAzureCredential)
+				return ok
+			},
+		},
+		"unknown": {
+			creds:   Credentials{AuthMethod: "password"},
+			wantErr: "unknown authentication method 'password'",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			credential, err := newTokenCredential(tt.creds)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.want(credential) {
+				t.Fatalf("unexpected credential %T", credential)
+			}
+		})
+	}
+}
+
+func isRotating(c azcore.TokenCredential) bool {
+	_, ok := c.(*rotatingCredential)
+	return ok
+}
+
+func TestRotatingCredential(t *testing.T) {
+	certPath := filepath.Join(t.TempDir(), "cert.pem")
+	writeCertificate(t, certPath)
+
+	clientSecret := "secret-1"
+
+	tests := map[string]struct {
+		creds  Credentials
+		rotate func(t *testing.T)
+	}{
+		"client secret": {
+			creds: Credentials{
+				AuthMethod: AuthClientSecret, TenantID: "tenant", ClientID: "client",
+				ClientSecret: func(context.Context) (string, error) { return clientSecret, nil },
+			},
+			rotate: func(*testing.T) { clientSecret = "secret-2" },
+		},
+		"certificate": {
+			creds:  Credentials{AuthMethod: AuthCertificate, TenantID: "tenant", ClientID: "client", CertificatePath: certPath},
+			rotate: func(t *testing.T) { writeCertificate(t, certPath) },
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			credential, err := newTokenCredential(tt.creds)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := credential.(*rotatingCredential)
+
+			first, err := r.current(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// unchanged material keeps the credential and its token cache
+			if again, err := r.current(context.Background()); err != nil || again != first {
+				t.Fatalf("expected the same credential, got %v", err)
+			}
+
+			tt.rotate(t)
+
+			rotated, err := r.current(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rotated == first {
+				t.Fatal("expected the credential to be rebuilt after the rotation")
+			}
+		})
+	}
+}
@@ -3,36 +3,100 @@ package sentinel
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/monitor/ingestion/azlogs"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/utils"
 	"github.com/sirupsen/logrus"
+	"net"
 )
 
-func (s *Sentinel) IngestLog(ctx context.Context, endpoint, ruleID, streamName string, logs []map[string]string) error {
-	logger := s.logger.WithField("module", "sentinel_ingest")
+// ingestClient returns the ingestion client for endpoint, creating it on first use.
+func (s *Sentinel) ingestClient(endpoint string) (*azlogs.Client, error) {
+	s.ingestLock.Lock()
+	defer s.ingestLock.Unlock()
+
+	if client, ok := s.ingestClients[endpoint]; ok {
+		return client, nil
+	}
 
-	ingest, err := azlogs.NewClient(endpoint, s.azCreds, nil)
+	// retries are handled by ingestPayloadWithRetry so they can honour our own backoff settings
+	client, err := azlogs.NewClient(endpoint, s.azCreds, &azlogs.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Retry: policy.RetryOptions{MaxRetries: -1},
+		},
+	})
 	if err != nil {
-		return fmt.Errorf("could not create azure ingest client: %v", err)
+		return nil, fmt.Errorf("could not create azure ingest client: %v", err)
 	}
 
+	s.ingestClients[endpoint] = client
+
+	return client, nil
+}
+
+func (s *Sentinel) IngestLog(ctx context.Context, endpoint, ruleID, streamName string, logs []record.Record) error {
 	logPayload, err := json.Marshal(&logs)
 	if err != nil {
 		return fmt.Errorf("could not json encode log message: %v", err)
 	}
 
+	return s.ingestPayloadWithRetry(ctx, endpoint, ruleID, streamName, logPayload, len(logs))
+}
+
+// ingestPayloadWithRetry uploads the payload and retries throttled, server and network errors with backoff.
+// Any other error, such as a failure to get a token, is returned right away.
+func (s *Sentinel) ingestPayloadWithRetry(ctx context.Context, endpoint, ruleID, streamName string, logPayload []byte, total int) error {
+	return s.opts.Retry.Do(ctx, s.logger, "sentinel_ingest", func() error {
+		err := s.ingestPayload(ctx, endpoint, ruleID, streamName, logPayload, total)
+
+		var respErr *azcore.ResponseError
+		var netErr net.Error
+
+		switch {
+		case err == nil:
+			return nil
+		case errors.As(err, &respErr):
+			statusErr := &sink.StatusError{StatusCode: respErr.StatusCode, Message: err.Error()}
+			if respErr.RawResponse != nil {
+				statusErr.RetryAfter = utils.ParseRetryAfter(respErr.RawResponse.Header.Get("Retry-After"))
+			}
+
+			return statusErr
+		case errors.As(err, &netErr):
+			// the request did not make it to the ingestion endpoint, which is worth another try
+			return err
+		default:
+			// credential and configuration errors fail the same way on every attempt
+			return sink.Permanent(err)
+		}
+	})
+}
+
+func (s *Sentinel) ingestPayload(ctx context.Context, endpoint, ruleID, streamName string, logPayload []byte, total int) error {
+	logger := s.logger.WithField("module", "sentinel_ingest")
+
+	ingest, err := s.ingestClient(endpoint)
+	if err != nil {
+		return err
+	}
+
 	if s.logger.IsLevelEnabled(logrus.TraceLevel) {
 		logger.Tracef("%s", string(logPayload))
 	}
 
-	logger.WithField("total", len(logs)).Debug("uploading logs")
+	logger.WithField("total", total).WithField("bytes", len(logPayload)).Debug("uploading logs")
 
 	_, err = ingest.Upload(ctx, ruleID, streamName, logPayload, nil)
 	if err != nil {
-		return fmt.Errorf("could not upload logs: %v", err)
+		return fmt.Errorf("could not upload logs: %w", err)
 	}
 
-	logger.WithField("total_logs", len(logs)).Debug("successfully uploaded 1password logs")
+	logger.WithField("total_logs", total).Debug("successfully uploaded 1password logs")
 
 	return nil
 }
@@ -0,0 +1,91 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/ingestion/azlogs"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeCredential struct {
+	calls int
+	err   error
+}
+
+func (c *fakeCredential) GetToken(context.Context, policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	c.calls++
+	return azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}, c.err
+}
+
+func newTestSentinel(t *testing.T, creds azcore.TokenCredential, server *httptest.Server) *Sentinel {
+	client, err := azlogs.NewClient(server.URL, creds, &azlogs.ClientOptions{
+		ClientOptions: policy.ClientOptions{
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+			Transport: server.Client(),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Sentinel{
+		logger:        logrus.New(),
+		opts:          Options{Retry: sink.Retry{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}},
+		azCreds:       creds,
+		ingestClients: map[string]*azlogs.Client{server.URL: client},
+	}
+}
+
+func TestIngestLog_Retry(t *testing.T) {
+	attempts := 0
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	s := newTestSentinel(t, &fakeCredential{}, server)
+
+	if err := s.IngestLog(context.Background(), server.URL, "dcr", "Custom-Stream", []record.Record{{"UUID": "a1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestIngestLog_CredentialError(t *testing.T) {
+	attempts := 0
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer server.Close()
+
+	creds := &fakeCredential{err: errors.New("invalid client secret")}
+	s := newTestSentinel(t, creds, server)
+
+	if err := s.IngestLog(context.Background(), server.URL, "dcr", "Custom-Stream", []record.Record{{"UUID": "a1"}}); err == nil {
+		t.Fatal("expected the credential error")
+	}
+
+	if creds.calls != 1 || attempts != 0 {
+		t.Fatalf("expected a single token request and no upload, got %d token requests and %d uploads", creds.calls, attempts)
+	}
+}
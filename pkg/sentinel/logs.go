@@ -2,52 +2,170 @@ package sentinel
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/hazcod/one2sen/pkg/record"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
+	"net/http"
 )
 
 const (
-	logsPerRequest = 10
+	// the Logs Ingestion API rejects calls with a payload over 1MB, keep some headroom
+	maxBatchBytes = 1000 * 1000
 )
 
-func chunkLogs(slice []map[string]string, chunkSize int) [][]map[string]string {
-	var chunks [][]map[string]string
-	for i := 0; i < len(slice); i += chunkSize {
-		end := i + chunkSize
+type logBatch struct {
+	logs    []record.Record
+	payload []byte
+}
+
+// FailedBatch holds logs which could not be uploaded, even after retrying.
+type FailedBatch struct {
+	Logs []record.Record
+	Err  error
+}
+
+// SendResult describes which batches of a SendLogs call were uploaded and which failed.
+type SendResult struct {
+	Batches   int
+	Succeeded int
+	Failed    []FailedBatch
+}
+
+// Err returns all batch errors joined together, or nil when every batch was uploaded.
+func (r *SendResult) Err() error {
+	errs := make([]error, len(r.Failed))
+	for i, failed := range r.Failed {
+		errs[i] = fmt.Errorf("batch of %d logs: %w", len(failed.Logs), failed.Err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// batchLogs splits logs into batches of which the JSON encoded payload stays below maxBytes.
+func batchLogs(logs []record.Record, maxBytes int) ([]logBatch, error) {
+	var batches []logBatch
+
+	current := logBatch{payload: []byte{'['}}
 
-		// avoid slicing beyond slice capacity
-		if end > len(slice) {
-			end = len(slice)
+	for _, log := range logs {
+		encoded, err := json.Marshal(&log)
+		if err != nil {
+			return nil, fmt.Errorf("could not json encode log message: %v", err)
 		}
 
-		chunks = append(chunks, slice[i:end])
+		// a single log which exceeds the limit on its own can never be uploaded
+		if len(encoded)+2 > maxBytes {
+			return nil, fmt.Errorf("log message of %d bytes exceeds the maximum of %d bytes", len(encoded), maxBytes)
+		}
+
+		// account for the separating comma and the closing bracket
+		if len(current.logs) > 0 && len(current.payload)+1+len(encoded)+1 > maxBytes {
+			current.payload = append(current.payload, ']')
+			batches = append(batches, current)
+			current = logBatch{payload: []byte{'['}}
+		}
+
+		if len(current.logs) > 0 {
+			current.payload = append(current.payload, ',')
+		}
+
+		current.payload = append(current.payload, encoded...)
+		current.logs = append(current.logs, log)
 	}
 
-	return chunks
+	if len(current.logs) > 0 {
+		current.payload = append(current.payload, ']')
+		batches = append(batches, current)
+	}
+
+	return batches, nil
 }
 
-func (s *Sentinel) SendLogs(ctx context.Context, l *logrus.Logger, endpoint, ruleID, streamName string, logs []map[string]string) error {
+// SendLogs uploads logs in size-bound batches using a pool of upload workers.
+// A failing batch does not stop the others; the returned result lists every batch that failed
+// and the returned error is non-nil when at least one batch failed.
+func (s *Sentinel) SendLogs(ctx context.Context, l *logrus.Logger, endpoint, ruleID, streamName string, logs []record.Record) (*SendResult, error) {
 	logger := l.WithField("module", "sentinel_logs")
 
-	logger.WithField("table_name", tableName).WithField("total", len(logs)).Info("shipping logs")
+	logger.WithField("stream_name", streamName).WithField("total", len(logs)).Debug("shipping logs")
+
+	batches, err := batchLogs(logs, maxBatchBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := make([][]FailedBatch, len(batches))
 
-	chunkedLogs := chunkLogs(logs, logsPerRequest)
-	for i, logsChunk := range chunkedLogs {
-		l.WithField("progress", fmt.Sprintf("%d/%d", i+1, len(chunkedLogs))).Debug("ingesting log chunks")
+	workers := errgroup.Group{}
+	workers.SetLimit(s.opts.UploadWorkers)
 
-		if len(logsChunk) == 0 {
-			l.Warn("processing empty chunk")
+	for i, batch := range batches {
+		workers.Go(func() error {
+			logger.WithField("progress", fmt.Sprintf("%d/%d", i+1, len(batches))).Debug("ingesting log batch")
+
+			failed[i] = s.ingestBatch(ctx, endpoint, ruleID, streamName, batch)
+			return nil
+		})
+	}
+
+	_ = workers.Wait()
+
+	result := SendResult{Batches: len(batches)}
+	for _, batchFailures := range failed {
+		if len(batchFailures) == 0 {
+			result.Succeeded++
 			continue
 		}
 
-		if err := s.IngestLog(ctx, endpoint, ruleID, streamName, logsChunk); err != nil {
-			return fmt.Errorf("could not ingest log: %v", err)
-		}
+		result.Failed = append(result.Failed, batchFailures...)
+	}
+
+	if err := result.Err(); err != nil {
+		logger.WithField("failed", len(result.Failed)).WithField("requests", len(batches)).Warn("not all logs could be shipped")
+		return &result, fmt.Errorf("could not ingest logs: %w", err)
 	}
 
 	//
 
-	logger.WithField("table_name", tableName).Info("shipped logs")
+	logger.WithField("stream_name", streamName).WithField("requests", len(batches)).Debug("shipped logs")
+
+	return &result, nil
+}
+
+// ingestBatch uploads a batch and splits it in half when the service still considers it too large.
+// It returns the parts of the batch that could not be uploaded.
+func (s *Sentinel) ingestBatch(ctx context.Context, endpoint, ruleID, streamName string, batch logBatch) []FailedBatch {
+	err := s.ingestPayloadWithRetry(ctx, endpoint, ruleID, streamName, batch.payload, len(batch.logs))
+	if err == nil {
+		return nil
+	}
+
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusRequestEntityTooLarge || len(batch.logs) < 2 {
+		return []FailedBatch{{Logs: batch.logs, Err: err}}
+	}
+
+	s.logger.WithField("total", len(batch.logs)).Debug("payload too large, splitting batch")
+
+	var failed []FailedBatch
+
+	half := len(batch.logs) / 2
+
+	for _, logs := range [][]record.Record{batch.logs[:half], batch.logs[half:]} {
+		smaller, err := batchLogs(logs, len(batch.payload))
+		if err != nil {
+			failed = append(failed, FailedBatch{Logs: logs, Err: err})
+			continue
+		}
+
+		for _, smallerBatch := range smaller {
+			failed = append(failed, s.ingestBatch(ctx, endpoint, ruleID, streamName, smallerBatch)...)
+		}
+	}
 
-	return nil
+	return failed
 }
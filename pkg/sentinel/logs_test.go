@@ -0,0 +1,50 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"github.com/hazcod/one2sen/pkg/record"
+	"strings"
+	"testing"
+)
+
+func TestBatchLogs(t *testing.T) {
+	logs := make([]record.Record, 50)
+	for i := range logs {
+		logs[i] = record.Record{"Data": strings.Repeat("x", 100)}
+	}
+
+	batches, err := batchLogs(logs, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for _, batch := range batches {
+		if len(batch.payload) > 1000 {
+			t.Fatalf("batch of %d bytes exceeds the limit", len(batch.payload))
+		}
+
+		var decoded []record.Record
+		if err := json.Unmarshal(batch.payload, &decoded); err != nil {
+			t.Fatalf("batch payload is not valid json: %v", err)
+		}
+
+		if len(decoded) != len(batch.logs) {
+			t.Fatalf("payload holds %d logs, expected %d", len(decoded), len(batch.logs))
+		}
+
+		total += len(batch.logs)
+	}
+
+	if total != len(logs) {
+		t.Fatalf("expected %d logs over all batches, got %d", len(logs), total)
+	}
+}
+
+func TestBatchLogs_TooLarge(t *testing.T) {
+	logs := []record.Record{{"Data": strings.Repeat("x", 2000)}}
+
+	if _, err := batchLogs(logs, 1000); err == nil {
+		t.Fatal("expected an error for a log exceeding the limit")
+	}
+}
@@ -0,0 +1,185 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	// name of the Log Analytics workspace destination inside the data collection rule
+	workspaceDestination = "workspace"
+
+	customStreamPrefix = "Custom-"
+)
+
+// Stream binds a data collection rule input stream to the table its logs are stored in.
+type Stream struct {
+	Name  string
+	Table Table
+}
+
+// Provisioning describes the data collection endpoint and rule to create or update.
+type Provisioning struct {
+	// Azure region, defaults to the region of the workspace
+	Location     string
+	EndpointName string
+	RuleName     string
+	Streams      []Stream
+}
+
+// Provisioned holds what is needed to upload logs through a provisioned data collection rule.
+type Provisioned struct {
+	Endpoint   string
+	EndpointID string
+	RuleID     string
+}
+
+// Provision creates or updates a data collection endpoint and a data collection rule sending every stream to its table.
+// The stream declarations are generated from the table columns, so the tables have to exist already.
+func (s *Sentinel) Provision(ctx context.Context, l *logrus.Logger, p Provisioning) (*Provisioned, error) {
+	logger := l.WithField("module", "sentinel_provision")
+
+	if len(p.Streams) == 0 {
+		return nil, errors.New("no streams to provision")
+	}
+
+	workspacesClient, err := insights.NewWorkspacesClient(s.creds.SubscriptionID, s.azCreds, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create workspace client: %v", err)
+	}
+
+	workspace, err := workspacesClient.Get(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get workspace '%s': %v", s.creds.WorkspaceName, err)
+	}
+
+	location := p.Location
+	if location == "" && workspace.Location != nil {
+		location = *workspace.Location
+	}
+
+	if location == "" || workspace.ID == nil {
+		return nil, fmt.Errorf("could not determine location of workspace '%s'", s.creds.WorkspaceName)
+	}
+
+	endpointsClient, err := armmonitor.NewDataCollectionEndpointsClient(s.creds.SubscriptionID, s.azCreds, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create data collection endpoint client: %v", err)
+	}
+
+	logger.WithField("endpoint_name", p.EndpointName).Info("creating or updating data collection endpoint")
+
+	endpoint, err := endpointsClient.Create(ctx, s.creds.ResourceGroup, p.EndpointName, &armmonitor.DataCollectionEndpointsClientCreateOptions{
+		Body: &armmonitor.DataCollectionEndpointResource{
+			Location: to.Ptr(location),
+			Properties: &armmonitor.DataCollectionEndpointResourceProperties{
+				Description: to.Ptr("Receives events ingested from 1Password."),
+				NetworkACLs: &armmonitor.DataCollectionEndpointNetworkACLs{
+					PublicNetworkAccess: to.Ptr(armmonitor.KnownPublicNetworkAccessOptionsEnabled),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create data collection endpoint '%s': %v", p.EndpointName, err)
+	}
+
+	if endpoint.ID == nil || endpoint.Properties == nil || endpoint.Properties.LogsIngestion == nil || endpoint.Properties.LogsIngestion.Endpoint == nil {
+		return nil, fmt.Errorf("data collection endpoint '%s' has no logs ingestion endpoint", p.EndpointName)
+	}
+
+	rule := armmonitor.DataCollectionRuleResourceProperties{
+		Description:              to.Ptr("Sends events ingested from 1Password to Log Analytics."),
+		DataCollectionEndpointID: endpoint.ID,
+		StreamDeclarations:       make(map[string]*armmonitor.StreamDeclaration, len(p.Streams)),
+		Destinations: &armmonitor.DataCollectionRuleDestinations{
+			LogAnalytics: []*armmonitor.LogAnalyticsDestination{{
+				Name:                to.Ptr(workspaceDestination),
+				WorkspaceResourceID: workspace.ID,
+			}},
+		},
+	}
+
+	for _, stream := range p.Streams {
+		if !strings.HasPrefix(stream.Name, customStreamPrefix) {
+			return nil, fmt.Errorf("stream name '%s' must start with %s", stream.Name, customStreamPrefix)
+		}
+
+		rule.StreamDeclarations[stream.Name] = StreamDeclaration(stream.Table)
+		rule.DataFlows = append(rule.DataFlows, &armmonitor.DataFlow{
+			Streams:      []*armmonitor.KnownDataFlowStreams{to.Ptr(armmonitor.KnownDataFlowStreams(stream.Name))},
+			Destinations: []*string{to.Ptr(workspaceDestination)},
+			TransformKql: to.Ptr("source"),
+			OutputStream: to.Ptr(customStreamPrefix + stream.Table.Name),
+		})
+	}
+
+	rulesClient, err := armmonitor.NewDataCollectionRulesClient(s.creds.SubscriptionID, s.azCreds, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create data collection rule client: %v", err)
+	}
+
+	logger.WithField("rule_name", p.RuleName).Info("creating or updating data collection rule")
+
+	created, err := rulesClient.Create(ctx, s.creds.ResourceGroup, p.RuleName, &armmonitor.DataCollectionRulesClientCreateOptions{
+		Body: &armmonitor.DataCollectionRuleResource{
+			Location:   to.Ptr(location),
+			Properties: &rule,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create data collection rule '%s': %v", p.RuleName, err)
+	}
+
+	if created.Properties == nil || created.Properties.ImmutableID == nil {
+		return nil, fmt.Errorf("data collection rule '%s' has no immutable id", p.RuleName)
+	}
+
+	logger.WithField("rule_name", p.RuleName).Info("provisioned data collection rule")
+
+	return &Provisioned{
+		Endpoint:   *endpoint.Properties.LogsIngestion.Endpoint,
+		EndpointID: *endpoint.ID,
+		RuleID:     *created.Properties.ImmutableID,
+	}, nil
+}
+
+// StreamDeclaration generates the data collection rule stream declaration matching the columns of table.
+func StreamDeclaration(table Table) *armmonitor.StreamDeclaration {
+	columns := make([]*armmonitor.ColumnDefinition, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[i] = &armmonitor.ColumnDefinition{
+			Name: to.Ptr(column.Name),
+			Type: to.Ptr(streamColumnType(column.Type)),
+		}
+	}
+
+	return &armmonitor.StreamDeclaration{Columns: columns}
+}
+
+// streamColumnType maps a Log Analytics column type onto the type a data collection rule stream expects.
+func streamColumnType(columnType insights.ColumnTypeEnum) armmonitor.KnownColumnDefinitionType {
+	switch columnType {
+	case insights.ColumnTypeEnumBoolean:
+		return armmonitor.KnownColumnDefinitionTypeBoolean
+	case insights.ColumnTypeEnumDateTime:
+		return armmonitor.KnownColumnDefinitionTypeDatetime
+	case insights.ColumnTypeEnumDynamic:
+		return armmonitor.KnownColumnDefinitionTypeDynamic
+	case insights.ColumnTypeEnumInt:
+		return armmonitor.KnownColumnDefinitionTypeInt
+	case insights.ColumnTypeEnumLong:
+		return armmonitor.KnownColumnDefinitionTypeLong
+	case insights.ColumnTypeEnumReal:
+		return armmonitor.KnownColumnDefinitionTypeReal
+	default:
+		// guids and strings are both sent as strings
+		return armmonitor.KnownColumnDefinitionTypeString
+	}
+}
@@ -0,0 +1,138 @@
+package sentinel
+
+import (
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+)
+
+// Column is a single typed column of a custom Log Analytics table.
+type Column struct {
+	Name string
+	Type insights.ColumnTypeEnum
+}
+
+// Table describes a custom Log Analytics table, its name has to end with _CL.
+type Table struct {
+	Name        string
+	Description string
+	Columns     []Column
+}
+
+var (
+	// LegacyTable holds every 1Password event in one table with most fields in the dynamic Data column.
+	LegacyTable = Table{
+		Name:        "OnePasswordLogs_CL",
+		Description: "Table that contains events ingested from 1Password.",
+		Columns: []Column{
+			{Name: "TimeGenerated", Type: insights.ColumnTypeEnumDateTime},
+			{Name: "LogType", Type: insights.ColumnTypeEnumString},
+			{Name: "User", Type: insights.ColumnTypeEnumDynamic},
+			{Name: "Client", Type: insights.ColumnTypeEnumDynamic},
+			{Name: "Location", Type: insights.ColumnTypeEnumDynamic},
+			{Name: "Data", Type: insights.ColumnTypeEnumDynamic},
+			accountColumn,
+			rawEventColumn,
+		},
+	}
+
+	// SigninTable holds 1Password sign-in attempts.
+	SigninTable = Table{
+		Name:        "OnePasswordSignins_CL",
+		Description: "Table that contains sign-in attempts ingested from 1Password.",
+		Columns: concatColumns(
+			[]Column{
+				{Name: "TimeGenerated", Type: insights.ColumnTypeEnumDateTime},
+				{Name: "UUID", Type: insights.ColumnTypeEnumString},
+				{Name: "SessionUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "Category", Type: insights.ColumnTypeEnumString},
+				{Name: "EventType", Type: insights.ColumnTypeEnumString},
+				{Name: "EventCountry", Type: insights.ColumnTypeEnumString},
+				{Name: "OK", Type: insights.ColumnTypeEnumBoolean},
+				{Name: "Details", Type: insights.ColumnTypeEnumDynamic},
+			},
+			actorColumns, clientColumns, locationColumns, []Column{accountColumn, rawEventColumn},
+		),
+	}
+
+	// UsageTable holds 1Password item usages.
+	UsageTable = Table{
+		Name:        "OnePasswordItemUsages_CL",
+		Description: "Table that contains item usages ingested from 1Password.",
+		Columns: concatColumns(
+			[]Column{
+				{Name: "TimeGenerated", Type: insights.ColumnTypeEnumDateTime},
+				{Name: "UUID", Type: insights.ColumnTypeEnumString},
+				{Name: "Action", Type: insights.ColumnTypeEnumString},
+				{Name: "VaultUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "ItemUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "UsedVersion", Type: insights.ColumnTypeEnumInt},
+			},
+			actorColumns, clientColumns, locationColumns, []Column{accountColumn, rawEventColumn},
+		),
+	}
+
+	// AuditTable holds 1Password audit events.
+	AuditTable = Table{
+		Name:        "OnePasswordAuditEvents_CL",
+		Description: "Table that contains audit events ingested from 1Password.",
+		Columns: concatColumns(
+			[]Column{
+				{Name: "TimeGenerated", Type: insights.ColumnTypeEnumDateTime},
+				{Name: "UUID", Type: insights.ColumnTypeEnumString},
+				{Name: "Action", Type: insights.ColumnTypeEnumString},
+				{Name: "ObjectType", Type: insights.ColumnTypeEnumString},
+				{Name: "ObjectUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "SessionUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "SessionLoginTime", Type: insights.ColumnTypeEnumDateTime},
+				{Name: "SessionDeviceUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "SessionIP", Type: insights.ColumnTypeEnumString},
+				{Name: "AuxID", Type: insights.ColumnTypeEnumInt},
+				{Name: "AuxUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "AuxDetailsUUID", Type: insights.ColumnTypeEnumString},
+				{Name: "AuxName", Type: insights.ColumnTypeEnumString},
+				{Name: "AuxEmail", Type: insights.ColumnTypeEnumString},
+				{Name: "AuxInfo", Type: insights.ColumnTypeEnumString},
+				{Name: "ActorDetailsUUID", Type: insights.ColumnTypeEnumString},
+			},
+			actorColumns, locationColumns, []Column{accountColumn, rawEventColumn},
+		),
+	}
+
+	// the 1Password account the event was collected from
+	accountColumn = Column{Name: "Account", Type: insights.ColumnTypeEnumString}
+
+	// only filled when raw events are enabled
+	rawEventColumn = Column{Name: "RawEvent", Type: insights.ColumnTypeEnumDynamic}
+
+	actorColumns = []Column{
+		{Name: "ActorUUID", Type: insights.ColumnTypeEnumString},
+		{Name: "ActorName", Type: insights.ColumnTypeEnumString},
+		{Name: "ActorEmail", Type: insights.ColumnTypeEnumString},
+	}
+
+	clientColumns = []Column{
+		{Name: "AppName", Type: insights.ColumnTypeEnumString},
+		{Name: "AppVersion", Type: insights.ColumnTypeEnumString},
+		{Name: "PlatformName", Type: insights.ColumnTypeEnumString},
+		{Name: "PlatformVersion", Type: insights.ColumnTypeEnumString},
+		{Name: "OSName", Type: insights.ColumnTypeEnumString},
+		{Name: "OSVersion", Type: insights.ColumnTypeEnumString},
+		{Name: "IPAddress", Type: insights.ColumnTypeEnumString},
+	}
+
+	locationColumns = []Column{
+		{Name: "Country", Type: insights.ColumnTypeEnumString},
+		{Name: "Region", Type: insights.ColumnTypeEnumString},
+		{Name: "City", Type: insights.ColumnTypeEnumString},
+		{Name: "Latitude", Type: insights.ColumnTypeEnumReal},
+		{Name: "Longitude", Type: insights.ColumnTypeEnumReal},
+	}
+)
+
+func concatColumns(columnSets ...[]Column) []Column {
+	var columns []Column
+	for _, set := range columnSets {
+		columns = append(columns, set...)
+	}
+
+	return columns
+}
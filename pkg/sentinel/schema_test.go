@@ -0,0 +1,89 @@
+package sentinel
+
+import (
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"sort"
+	"testing"
+)
+
+const testTimestamp = "2024-01-02T03:04:05.123Z"
+
+func columnNames(table Table) []string {
+	names := make([]string, 0, len(table.Columns))
+	for _, column := range table.Columns {
+		// the account and raw event are added separately from the converters
+		if column.Name == onepassword.AccountColumn || column.Name == onepassword.RawEventColumn {
+			continue
+		}
+
+		names = append(names, column.Name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func logKeys(log record.Record) []string {
+	keys := make([]string, 0, len(log))
+	for key := range log {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func assertColumns(t *testing.T, table Table, logs []record.Record, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	columns, keys := columnNames(table), logKeys(logs[0])
+	if len(columns) != len(keys) {
+		t.Fatalf("%s has columns %v, converter returns %v", table.Name, columns, keys)
+	}
+
+	for i := range columns {
+		if columns[i] != keys[i] {
+			t.Fatalf("%s has columns %v, converter returns %v", table.Name, columns, keys)
+		}
+	}
+}
+
+func TestTables_MatchConverters(t *testing.T) {
+	signins, err := onepassword.ConvertSigninToFlatMap(nil, []onepassword.Event{{Timestamp: testTimestamp}})
+	assertColumns(t, SigninTable, signins, err)
+
+	usages, err := onepassword.ConvertUsageToFlatMap(nil, []onepassword.Item{{Timestamp: testTimestamp}})
+	assertColumns(t, UsageTable, usages, err)
+
+	audits, err := onepassword.ConvertAuditEventToFlatMap(nil, []onepassword.AuditEvent{{Timestamp: testTimestamp}})
+	assertColumns(t, AuditTable, audits, err)
+}
+
+func TestStreamDeclaration_MatchesTables(t *testing.T) {
+	for _, table := range []Table{LegacyTable, SigninTable, UsageTable, AuditTable} {
+		declaration := StreamDeclaration(table)
+
+		if len(declaration.Columns) != len(table.Columns) {
+			t.Fatalf("%s declares %d columns, table has %d", table.Name, len(declaration.Columns), len(table.Columns))
+		}
+
+		for i, column := range table.Columns {
+			if *declaration.Columns[i].Name != column.Name {
+				t.Fatalf("%s declares column %s, expected %s", table.Name, *declaration.Columns[i].Name, column.Name)
+			}
+
+			if column.Type == insights.ColumnTypeEnumDateTime && *declaration.Columns[i].Type != armmonitor.KnownColumnDefinitionTypeDatetime {
+				t.Fatalf("%s column %s is declared as %s", table.Name, column.Name, *declaration.Columns[i].Type)
+			}
+		}
+	}
+}
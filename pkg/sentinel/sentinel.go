@@ -2,38 +2,69 @@ package sentinel
 
 import (
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/ingestion/azlogs"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
 	"github.com/hazcod/one2sen/pkg/utils"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"sync"
+)
+
+const (
+	defaultUploadWorkers = 4
 )
 
 type Credentials struct {
-	TenantID       string
-	ClientID       string
-	ClientSecret   string
+	AuthMethod AuthMethod
+
+	TenantID            string
+	ClientID            string
+	ClientSecret        secret.Func
+	CertificatePath     string
+	CertificatePassword secret.Func
+	FederatedTokenFile  string
+
 	SubscriptionID string
 	ResourceGroup  string
 	WorkspaceName  string
 }
 
+// Options tune how logs are uploaded, without UploadWorkers 4 batches are uploaded at a time.
+type Options struct {
+	UploadWorkers int
+	Retry         sink.Retry
+}
+
 type Sentinel struct {
 	creds  Credentials
+	opts   Options
 	logger *logrus.Logger
 
-	azCreds    *azidentity.ClientSecretCredential
+	azCreds    azcore.TokenCredential
 	httpClient *http.Client
+
+	ingestLock    sync.Mutex
+	ingestClients map[string]*azlogs.Client
 }
 
-func New(logger *logrus.Logger, creds Credentials) (*Sentinel, error) {
+func New(logger *logrus.Logger, creds Credentials, opts Options) (*Sentinel, error) {
+	if opts.UploadWorkers <= 0 {
+		opts.UploadWorkers = defaultUploadWorkers
+	}
+
 	sentinel := Sentinel{
 		creds:  creds,
+		opts:   opts,
 		logger: logger,
+
+		ingestClients: make(map[string]*azlogs.Client),
 	}
 
 	sentinel.httpClient = utils.NewLogHttpClient(logger)
 
-	azCreds, err := azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.ClientSecret, nil)
+	azCreds, err := newTokenCredential(creds)
 	if err != nil {
 		return nil, fmt.Errorf("could not authenticate to MS Sentinel: %v", err)
 	}
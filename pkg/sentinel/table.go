@@ -10,12 +10,8 @@ import (
 	"time"
 )
 
-const (
-	// has to end with _CL
-	tableName = "OnePasswordLogs_CL"
-)
-
-func (s *Sentinel) CreateTable(ctx context.Context, l *logrus.Logger, retentionDays uint32) error {
+// CreateTable creates or updates the custom Log Analytics table with the columns of table.
+func (s *Sentinel) CreateTable(ctx context.Context, l *logrus.Logger, table Table, retentionDays uint32) error {
 	logger := l.WithField("module", "sentinel_vuln")
 
 	tablesClient, err := insights.NewTablesClient(s.creds.SubscriptionID, s.azCreds, nil)
@@ -25,52 +21,35 @@ func (s *Sentinel) CreateTable(ctx context.Context, l *logrus.Logger, retentionD
 
 	retention := int32(retentionDays)
 
-	logger.WithField("table_name", tableName).Info("creating or updating table")
+	columns := make([]*insights.Column, len(table.Columns))
+	for i, column := range table.Columns {
+		columns[i] = &insights.Column{
+			Name: to.Ptr[string](column.Name),
+			Type: to.Ptr[insights.ColumnTypeEnum](column.Type),
+		}
+	}
+
+	logger.WithField("table_name", table.Name).Info("creating or updating table")
 
-	if _, err = tablesClient.Migrate(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, tableName, nil); err != nil {
+	if _, err = tablesClient.Migrate(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, table.Name, nil); err != nil {
 		logger.WithError(err).Debug("could not migrate table")
 	}
 
 	poller, err := tablesClient.BeginCreateOrUpdate(ctx,
-		s.creds.ResourceGroup, s.creds.WorkspaceName, tableName,
+		s.creds.ResourceGroup, s.creds.WorkspaceName, table.Name,
 		insights.Table{
 			Properties: &insights.TableProperties{
 				RetentionInDays:      &retention,
 				TotalRetentionInDays: to.Ptr[int32](retention * 2),
 				Schema: &insights.Schema{
-					Columns: []*insights.Column{
-						{
-							Name: to.Ptr[string]("TimeGenerated"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDateTime),
-						},
-						{
-							Name: to.Ptr[string]("LogType"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("User"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDynamic),
-						},
-						{
-							Name: to.Ptr[string]("Client"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDynamic),
-						},
-						{
-							Name: to.Ptr[string]("Location"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDynamic),
-						},
-						{
-							Name: to.Ptr[string]("Data"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDynamic),
-						},
-					},
-					Name:        to.Ptr[string](tableName),
-					Description: to.Ptr[string]("Table that contains events ingested from 1Password."),
+					Columns:     columns,
+					Name:        to.Ptr[string](table.Name),
+					Description: to.Ptr[string](table.Description),
 				},
 			},
 		}, nil)
 	if err != nil {
-		return fmt.Errorf("could not create table '%s': %v", tableName, err)
+		return fmt.Errorf("could not create table '%s': %v", table.Name, err)
 	}
 
 	_, err = poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: time.Second})
@@ -78,7 +57,7 @@ func (s *Sentinel) CreateTable(ctx context.Context, l *logrus.Logger, retentionD
 		return fmt.Errorf("could not poll table creation: %v", err)
 	}
 
-	logger.WithField("table_name", tableName).Info("created table")
+	logger.WithField("table_name", table.Name).Info("created table")
 
 	return nil
 }
@@ -0,0 +1,141 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxRetries     = 5
+	defaultRetryBaseDelay = time.Second
+	defaultRetryMaxDelay  = time.Minute
+)
+
+// Retry configures how a sink retries a failed request.
+// Zero values retry 5 times with a delay growing from a second up to a minute.
+type Retry struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+// StatusError is returned for a rejected HTTP request, only throttled requests and server errors are retried.
+type StatusError struct {
+	StatusCode int
+	Message    string
+	// the delay asked for by the server through Retry-After
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code %d: %s", e.StatusCode, e.Message)
+}
+
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// NewStatusError creates a StatusError for resp with the response body as message.
+func NewStatusError(resp *http.Response, body []byte) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Message:    string(body),
+		RetryAfter: utils.ParseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as an error which sending again will not resolve, so Do returns it right away.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Do calls fn until it succeeds, with an exponential backoff between the attempts.
+// It stops at MaxRetries, a permanent error or a StatusError which is not retryable.
+func (r Retry) Do(ctx context.Context, logger *logrus.Logger, module string, fn func() error) error {
+	if r.MaxRetries <= 0 {
+		r.MaxRetries = defaultMaxRetries
+	}
+
+	if r.BaseDelay <= 0 {
+		r.BaseDelay = defaultRetryBaseDelay
+	}
+
+	if r.MaxDelay <= 0 {
+		r.MaxDelay = defaultRetryMaxDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		wait := utils.Backoff(attempt, r.BaseDelay, r.MaxDelay)
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			if !statusErr.Retryable() {
+				return err
+			}
+
+			if statusErr.RetryAfter > wait {
+				wait = statusErr.RetryAfter
+			}
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if attempt >= r.MaxRetries {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+
+		logger.WithError(err).WithField("module", module).WithField("attempt", attempt+1).
+			WithField("wait", wait.String()).Warn("retrying request")
+
+		if err := utils.Sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// Chunk splits items into consecutive chunks of at most maxBytes, an item larger than maxBytes gets its own chunk.
+func Chunk[T any](items []T, maxBytes int, size func(T) int) [][]T {
+	var chunks [][]T
+
+	for len(items) > 0 {
+		end, total := 0, 0
+		for end < len(items) && (end == 0 || total+size(items[end]) <= maxBytes) {
+			total += size(items[end])
+			end++
+		}
+
+		chunks = append(chunks, items[:end])
+		items = items[end:]
+	}
+
+	return chunks
+}
@@ -0,0 +1,52 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetry_Do(t *testing.T) {
+	retry := Retry{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{name: "server error", err: &StatusError{StatusCode: http.StatusBadGateway}, attempts: 3},
+		{name: "throttled", err: &StatusError{StatusCode: http.StatusTooManyRequests}, attempts: 3},
+		{name: "bad request", err: &StatusError{StatusCode: http.StatusBadRequest}, attempts: 1},
+		{name: "permanent", err: Permanent(errors.New("invalid")), attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+
+			err := retry.Do(context.Background(), logrus.New(), "test", func() error {
+				attempts++
+				return tt.err
+			})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if attempts != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, attempts)
+			}
+		})
+	}
+}
+
+func TestChunk(t *testing.T) {
+	// the item larger than the limit gets a chunk of its own
+	chunks := Chunk([]int{4, 4, 4, 12, 1}, 10, func(i int) int { return i })
+
+	if len(chunks) != 4 || len(chunks[0]) != 2 || len(chunks[1]) != 1 || chunks[2][0] != 12 || chunks[3][0] != 1 {
+		t.Fatalf("unexpected chunks: %v", chunks)
+	}
+}
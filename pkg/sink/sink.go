@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	"sync"
+)
+
+// Batch is a page of converted logs of a single stream of a 1Password account.
+type Batch struct {
+	Account string
+	Stream  string
+	Logs    []record.Record
+}
+
+// Sink delivers converted logs to an output.
+// Send only returns once the logs were delivered, so the caller can advance its checkpoint.
+type Sink interface {
+	Name() string
+	Send(ctx context.Context, batch Batch) error
+	Close() error
+}
+
+// RejectedError is returned by a sink which delivered a batch except for the logs in Rejected,
+// which the output refused and would refuse again when sent as they are.
+type RejectedError struct {
+	Rejected Batch
+	Err      error
+}
+
+func (e *RejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// Fallback takes over a batch which s failed to deliver, for example by spooling it to replay later.
+type Fallback func(s Sink, batch Batch, err error) error
+
+// Multi sends every batch to all of its sinks at the same time.
+// When only some sinks failed, the batch is handed to Fallback for each of them and counts as delivered,
+// so it is never sent again to the sinks which accepted it. Of a sink returning a RejectedError,
+// only the rejected logs are handed to Fallback.
+// Without Fallback, or when every sink failed, a batch only counts as delivered once every sink accepted it.
+type Multi struct {
+	Sinks    []Sink
+	Fallback Fallback
+}
+
+func (m *Multi) Name() string {
+	return "multi"
+}
+
+func (m *Multi) Send(ctx context.Context, batch Batch) error {
+	if len(m.Sinks) == 1 && m.Fallback == nil {
+		return m.Sinks[0].Send(ctx, batch)
+	}
+
+	errs := make([]error, len(m.Sinks))
+
+	var wg sync.WaitGroup
+	for i, s := range m.Sinks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = s.Send(ctx, batch)
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	undelivered := 0
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		failed = append(failed, fmt.Errorf("%s: %w", m.Sinks[i].Name(), err))
+
+		var rejected *RejectedError
+		if !errors.As(err, &rejected) {
+			undelivered++
+		}
+	}
+
+	// a batch which no sink accepted can be sent again without duplicating anything
+	if len(failed) == 0 || m.Fallback == nil || undelivered == len(m.Sinks) {
+		return errors.Join(failed...)
+	}
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		failedBatch := batch
+
+		var rejected *RejectedError
+		if errors.As(err, &rejected) {
+			failedBatch = rejected.Rejected
+		}
+
+		if fallbackErr := m.Fallback(m.Sinks[i], failedBatch, err); fallbackErr != nil {
+			return fmt.Errorf("%s: %w, and the fallback failed: %v", m.Sinks[i].Name(), err, fallbackErr)
+		}
+	}
+
+	return nil
+}
+
+func (m *Multi) Close() error {
+	var errs []error
+	for _, s := range m.Sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("could not close %s: %w", s.Name(), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	"sync"
+	"testing"
+)
+
+type fakeSink struct {
+	name string
+	err  error
+
+	mu      sync.Mutex
+	batches []Batch
+}
+
+func (s *fakeSink) Name() string {
+	return s.name
+}
+
+func (s *fakeSink) Send(_ context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches = append(s.batches, batch)
+
+	return s.err
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func TestMulti_Send(t *testing.T) {
+	batch := Batch{Account: "eu", Stream: "auditevents", Logs: []record.Record{{"UUID": "a1"}}}
+	failure := errors.New("unavailable")
+	rejected := &RejectedError{
+		Rejected: Batch{Account: batch.Account, Stream: batch.Stream, Logs: []record.Record{{"UUID": "a2"}}},
+		Err:      fmt.Errorf("mapping conflict: %w", failure),
+	}
+
+	tests := []struct {
+		name        string
+		errs        []error
+		fallback    bool
+		fallbackErr error
+		wantErr     bool
+		// the sinks the batch was handed to the fallback for
+		wantFallback []string
+		// the log handed to the fallback
+		wantUUID string
+	}{
+		{name: "all accepted", errs: []error{nil, nil}, fallback: true},
+		{name: "rejected without fallback", errs: []error{rejected}, wantErr: true},
+		{name: "rejected", errs: []error{rejected}, fallback: true, wantFallback: []string{"s0"}, wantUUID: "a2"},
+		{name: "rejected while another failed", errs: []error{rejected, failure}, fallback: true, wantFallback: []string{"s0", "s1"}, wantUUID: "a2"},
+		{name: "one failed without fallback", errs: []error{nil, failure}, wantErr: true},
+		{name: "one failed", errs: []error{nil, failure}, fallback: true, wantFallback: []string{"s1"}, wantUUID: "a1"},
+		{name: "all failed", errs: []error{failure, failure}, fallback: true, wantErr: true},
+		{name: "fallback failed", errs: []error{failure, nil}, fallback: true, fallbackErr: errors.New("disk full"), wantErr: true, wantFallback: []string{"s0"}, wantUUID: "a1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multi := &Multi{}
+			for i, err := range tt.errs {
+				multi.Sinks = append(multi.Sinks, &fakeSink{name: fmt.Sprintf("s%d", i), err: err})
+			}
+
+			var fallback []string
+			if tt.fallback {
+				multi.Fallback = func(s Sink, b Batch, err error) error {
+					if b.Account != batch.Account || !errors.Is(err, failure) {
+						t.Fatalf("unexpected fallback for %s: %v", s.Name(), err)
+					}
+
+					// only the rejected logs are handed over for a sink which accepted the others
+					if len(fallback) == 0 && b.Logs[0]["UUID"] != tt.wantUUID {
+						t.Fatalf("unexpected logs for %s: %v", s.Name(), b.Logs)
+					}
+
+					fallback = append(fallback, s.Name())
+					return tt.fallbackErr
+				}
+			}
+
+			err := multi.Send(context.Background(), batch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			if fmt.Sprint(fallback) != fmt.Sprint(tt.wantFallback) {
+				t.Fatalf("expected fallback for %v, got %v", tt.wantFallback, fallback)
+			}
+
+			// every sink gets the batch exactly once
+			for _, s := range multi.Sinks {
+				if sent := len(s.(*fakeSink).batches); sent != 1 {
+					t.Fatalf("expected %s to get the batch once, got %d", s.Name(), sent)
+				}
+			}
+		})
+	}
+}
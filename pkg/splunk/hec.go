@@ -0,0 +1,335 @@
+package splunk
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventPath = "/services/collector/event"
+	ackPath   = "/services/collector/ack"
+
+	// the default maximum content length of a HEC request is 1MB
+	defaultMaxBatchBytes = 1000 * 1000
+	defaultAckTimeout    = time.Minute
+	defaultAckInterval   = time.Second
+
+	defaultSourceTypePrefix = "1password:"
+)
+
+// Options configure the Splunk HTTP Event Collector sink.
+type Options struct {
+	URL   string
+	Token secret.Func
+
+	Index  string
+	Source string
+	// defaults to 1password:<stream>
+	SourceType string
+	Host       string
+
+	// wait until Splunk acknowledged that the events were indexed
+	Ack         bool
+	AckTimeout  time.Duration
+	AckInterval time.Duration
+
+	MaxBatchBytes int
+	Retry         sink.Retry
+}
+
+// HEC sends logs to a Splunk HTTP Event Collector.
+type HEC struct {
+	logger     *logrus.Logger
+	opts       Options
+	httpClient *http.Client
+
+	// identifies this client to the collector, needed for acknowledgements
+	channel string
+}
+
+type hecEvent struct {
+	Time       *float64      `json:"time,omitempty"`
+	Host       string        `json:"host,omitempty"`
+	Source     string        `json:"source,omitempty"`
+	SourceType string        `json:"sourcetype,omitempty"`
+	Index      string        `json:"index,omitempty"`
+	Event      record.Record `json:"event"`
+}
+
+type hecResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+func New(logger *logrus.Logger, opts Options) (*HEC, error) {
+	if opts.URL == "" {
+		return nil, errors.New("no splunk url provided")
+	}
+
+	if opts.Token == nil {
+		return nil, errors.New("no splunk token provided")
+	}
+
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+
+	if opts.AckTimeout <= 0 {
+		opts.AckTimeout = defaultAckTimeout
+	}
+
+	if opts.AckInterval <= 0 {
+		opts.AckInterval = defaultAckInterval
+	}
+
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = defaultMaxBatchBytes
+	}
+
+	channel, err := newChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	return &HEC{
+		logger:     logger,
+		opts:       opts,
+		httpClient: utils.NewLogHttpClient(logger),
+		channel:    channel,
+	}, nil
+}
+
+// newChannel generates a random channel identifier in the UUID format Splunk expects.
+func newChannel() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate splunk channel: %v", err)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func (h *HEC) Name() string {
+	return "splunk"
+}
+
+func (h *HEC) Close() error {
+	return nil
+}
+
+// Send uploads the batch in requests below the maximum size and, when enabled, waits for their acknowledgement.
+func (h *HEC) Send(ctx context.Context, batch sink.Batch) error {
+	payloads, err := h.encode(batch)
+	if err != nil {
+		return err
+	}
+
+	var ackIDs []int64
+
+	for _, payload := range payloads {
+		body, err := h.postWithRetry(ctx, eventPath, payload)
+		if err != nil {
+			return err
+		}
+
+		var resp hecResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return fmt.Errorf("could not decode splunk response: %v", err)
+		}
+
+		if h.opts.Ack {
+			if resp.AckID == nil {
+				return errors.New("splunk did not return an ack id, is indexer acknowledgement enabled for the token?")
+			}
+
+			ackIDs = append(ackIDs, *resp.AckID)
+		}
+	}
+
+	if len(ackIDs) > 0 {
+		if err := h.waitForAcks(ctx, ackIDs); err != nil {
+			return err
+		}
+	}
+
+	h.logger.WithField("module", "splunk").WithField("stream", batch.Stream).WithField("total", len(batch.Logs)).
+		WithField("requests", len(payloads)).Debug("sent logs to splunk")
+
+	return nil
+}
+
+// encode converts logs into HEC events and splits them into payloads of at most MaxBatchBytes.
+func (h *HEC) encode(batch sink.Batch) ([][]byte, error) {
+	sourceType := h.opts.SourceType
+	if sourceType == "" {
+		sourceType = defaultSourceTypePrefix + batch.Stream
+	}
+
+	var payloads [][]byte
+	var current []byte
+
+	for _, log := range batch.Logs {
+		event := hecEvent{
+			Time:       eventTime(log),
+			Host:       h.opts.Host,
+			Source:     h.opts.Source,
+			SourceType: sourceType,
+			Index:      h.opts.Index,
+			Event:      log,
+		}
+
+		encoded, err := json.Marshal(&event)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode splunk event: %v", err)
+		}
+
+		if len(encoded) > h.opts.MaxBatchBytes {
+			return nil, fmt.Errorf("splunk event of %d bytes exceeds the maximum of %d bytes", len(encoded), h.opts.MaxBatchBytes)
+		}
+
+		if len(current) > 0 && len(current)+len(encoded) > h.opts.MaxBatchBytes {
+			payloads = append(payloads, current)
+			current = nil
+		}
+
+		current = append(current, encoded...)
+	}
+
+	if len(current) > 0 {
+		payloads = append(payloads, current)
+	}
+
+	return payloads, nil
+}
+
+// eventTime returns the TimeGenerated column as epoch seconds, or nil to let Splunk pick the time.
+func eventTime(log record.Record) *float64 {
+	value, ok := log["TimeGenerated"].(string)
+	if !ok {
+		return nil
+	}
+
+	t, err := onepassword.ParseTimeGenerated(value)
+	if err != nil {
+		return nil
+	}
+
+	seconds := float64(t.UnixNano()) / float64(time.Second)
+	return &seconds
+}
+
+// postWithRetry posts payload to path, retrying throttled and failed requests.
+func (h *HEC) postWithRetry(ctx context.Context, path string, payload []byte) ([]byte, error) {
+	var body []byte
+
+	err := h.opts.Retry.Do(ctx, h.logger, "splunk", func() error {
+		var err error
+		body, err = h.post(ctx, path, payload)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+func (h *HEC) post(ctx context.Context, path string, payload []byte) ([]byte, error) {
+	token, err := h.opts.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get splunk token: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.opts.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("could not create splunk request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Splunk "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Splunk-Request-Channel", h.channel)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach splunk: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read splunk response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var hecResp hecResponse
+		_ = json.Unmarshal(body, &hecResp)
+
+		statusErr := sink.NewStatusError(resp, body)
+		statusErr.Message = hecResp.Text
+
+		return nil, fmt.Errorf("splunk rejected the request: %w", statusErr)
+	}
+
+	return body, nil
+}
+
+// waitForAcks polls the collector until all ackIDs were indexed or the ack timeout passed.
+func (h *HEC) waitForAcks(ctx context.Context, ackIDs []int64) error {
+	ctx, cancel := context.WithTimeout(ctx, h.opts.AckTimeout)
+	defer cancel()
+
+	pending := ackIDs
+
+	for {
+		payload, err := json.Marshal(map[string][]int64{"acks": pending})
+		if err != nil {
+			return fmt.Errorf("could not encode splunk ack request: %v", err)
+		}
+
+		// the events were already accepted, so failing on a single failed poll would only have them indexed twice
+		body, err := h.postWithRetry(ctx, ackPath, payload)
+		if err != nil {
+			return fmt.Errorf("could not query splunk acknowledgements: %w", err)
+		}
+
+		var resp struct {
+			Acks map[string]bool `json:"acks"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return fmt.Errorf("could not decode splunk ack response: %v", err)
+		}
+
+		var stillPending []int64
+		for _, id := range pending {
+			if !resp.Acks[strconv.FormatInt(id, 10)] {
+				stillPending = append(stillPending, id)
+			}
+		}
+
+		if len(stillPending) == 0 {
+			return nil
+		}
+		pending = stillPending
+
+		if err := utils.Sleep(ctx, h.opts.AckInterval); err != nil {
+			return fmt.Errorf("%d splunk requests were not acknowledged: %w", len(pending), err)
+		}
+	}
+}
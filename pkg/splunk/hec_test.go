@@ -0,0 +1,117 @@
+package splunk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHEC is a local stand-in for a Splunk HTTP Event Collector with indexer acknowledgement.
+type fakeHEC struct {
+	mu      sync.Mutex
+	events  []hecEvent
+	nextAck int64
+	polls   int
+	// the number of ack polls which fail before the collector answers them
+	ackFailures int
+}
+
+func (f *fakeHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Splunk token" || r.Header.Get("X-Splunk-Request-Channel") == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+
+	switch r.URL.Path {
+	case eventPath:
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		for decoder.More() {
+			var event hecEvent
+			if err := decoder.Decode(&event); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			f.events = append(f.events, event)
+		}
+
+		_, _ = fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, f.nextAck)
+		f.nextAck++
+
+	case ackPath:
+		if f.ackFailures > 0 {
+			f.ackFailures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct {
+			Acks []int64 `json:"acks"`
+		}
+		_ = json.Unmarshal(body, &req)
+
+		// only acknowledge on the second poll
+		f.polls++
+		acks := map[string]bool{}
+		for _, id := range req.Acks {
+			acks[fmt.Sprint(id)] = f.polls > 1
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"acks": acks})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestHEC_Send(t *testing.T) {
+	fake := &fakeHEC{ackFailures: 1}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	hec, err := New(logrus.New(), Options{
+		URL:           server.URL,
+		Token:         secret.Static("token"),
+		Index:         "security",
+		Ack:           true,
+		AckInterval:   time.Millisecond,
+		MaxBatchBytes: 200,
+		Retry:         sink.Retry{BaseDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := []record.Record{
+		{"TimeGenerated": "2024-01-02T03:04:05Z", "Action": "fill"},
+		{"TimeGenerated": "2024-01-02T03:04:06Z", "Action": "reveal"},
+		{"TimeGenerated": "2024-01-02T03:04:07Z", "Action": "copy"},
+	}
+
+	if err := hec.Send(context.Background(), sink.Batch{Account: "default", Stream: "itemusages", Logs: logs}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.events) != 3 || fake.nextAck < 2 {
+		t.Fatalf("expected 3 events in multiple requests, got %d events in %d requests", len(fake.events), fake.nextAck)
+	}
+
+	event := fake.events[0]
+	if event.SourceType != "1password:itemusages" || event.Index != "security" || event.Time == nil || *event.Time != 1704164645 {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+}
@@ -0,0 +1,183 @@
+package spool
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	fileExtension = ".jsonl"
+
+	// allow for large log lines when reading back spooled batches
+	maxLineBytes = 4 * 1024 * 1024
+)
+
+// Metadata describes where a spooled batch was supposed to go and why it did not make it.
+// It is stored as the first line of every spool file, followed by one log per line.
+// A batch is either a Sentinel upload to a DCR stream, or a page of a 1Password stream which Sink failed to accept.
+type Metadata struct {
+	Endpoint   string `json:"endpoint,omitempty"`
+	RuleID     string `json:"rule_id,omitempty"`
+	StreamName string `json:"stream_name,omitempty"`
+
+	Sink    string `json:"sink,omitempty"`
+	Account string `json:"account,omitempty"`
+	Stream  string `json:"stream,omitempty"`
+
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+	Total     int       `json:"total"`
+}
+
+// Spool is a directory of batches which failed to upload and should be replayed later.
+type Spool struct {
+	dir string
+}
+
+func New(dir string) (*Spool, error) {
+	if dir == "" {
+		return nil, errors.New("no spool directory provided")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create spool directory '%s': %v", dir, err)
+	}
+
+	return &Spool{dir: dir}, nil
+}
+
+// Write stores a failed batch as a new spool file and returns its path.
+// The file only appears under its final name once it has been completely written.
+func (s *Spool) Write(meta Metadata, logs []record.Record) (string, error) {
+	if meta.Timestamp.IsZero() {
+		meta.Timestamp = time.Now().UTC()
+	}
+	meta.Total = len(logs)
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("could not generate spool file name: %v", err)
+	}
+
+	name := fmt.Sprintf("%s-%s%s", meta.Timestamp.Format("20060102T150405.000000000Z"), hex.EncodeToString(suffix), fileExtension)
+	path := filepath.Join(s.dir, name)
+
+	tmpFile, err := os.CreateTemp(s.dir, name+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("could not create spool file: %v", err)
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+
+	err = encoder.Encode(&meta)
+	for i := 0; err == nil && i < len(logs); i++ {
+		err = encoder.Encode(&logs[i])
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("could not write spool file: %v", err)
+	}
+
+	return path, nil
+}
+
+// List returns the paths of all spooled batches, oldest first.
+func (s *Spool) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list spool directory '%s': %v", s.dir, err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), fileExtension) {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(s.dir, entry.Name()))
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// Read loads a spooled batch.
+func (s *Spool) Read(path string) (Metadata, []record.Record, error) {
+	var meta Metadata
+
+	file, err := os.Open(path)
+	if err != nil {
+		return meta, nil, fmt.Errorf("could not open spool file '%s': %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+
+	if !scanner.Scan() {
+		return meta, nil, fmt.Errorf("spool file '%s' has no metadata: %v", path, scanner.Err())
+	}
+
+	if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+		return meta, nil, fmt.Errorf("could not decode metadata of '%s': %v", path, err)
+	}
+
+	logs := make([]record.Record, 0, meta.Total)
+	for scanner.Scan() {
+		// keep numbers as they were written instead of converting them to floats
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+
+		var log record.Record
+		if err := decoder.Decode(&log); err != nil {
+			return meta, nil, fmt.Errorf("could not decode log in '%s': %v", path, err)
+		}
+
+		logs = append(logs, log)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return meta, nil, fmt.Errorf("could not read spool file '%s': %v", path, err)
+	}
+
+	if len(logs) != meta.Total {
+		return meta, nil, fmt.Errorf("spool file '%s' holds %d logs, expected %d", path, len(logs), meta.Total)
+	}
+
+	return meta, logs, nil
+}
+
+// Remove deletes a spooled batch once it has been replayed.
+func (s *Spool) Remove(path string) error {
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("could not remove spool file '%s': %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package spool
+
+import (
+	"encoding/json"
+	"github.com/hazcod/one2sen/pkg/record"
+	"testing"
+)
+
+func TestSpool_WriteRead(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := []record.Record{
+		{"LogType": "Event", "Data": map[string]any{"OK": true}},
+		{"LogType": "Audit", "Latitude": 50.85},
+	}
+
+	path, err := s.Write(Metadata{Endpoint: "https://dce", RuleID: "dcr-1", StreamName: "Custom-Stream", Error: "boom"}, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != 1 || paths[0] != path {
+		t.Fatalf("unexpected spool files: %v", paths)
+	}
+
+	meta, readLogs, err := s.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if meta.RuleID != "dcr-1" || meta.Total != 2 || len(readLogs) != 2 || readLogs[1]["Latitude"] != json.Number("50.85") {
+		t.Fatalf("unexpected spool contents: %+v %v", meta, readLogs)
+	}
+
+	if err := s.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if paths, _ := s.List(); len(paths) != 0 {
+		t.Fatalf("expected empty spool, got %v", paths)
+	}
+}
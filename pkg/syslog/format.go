@@ -0,0 +1,304 @@
+package syslog
+
+import (
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+
+	deviceVendor  = "1Password"
+	deviceProduct = "Events API"
+	deviceVersion = "1"
+
+	// LEEF 2.0 allows choosing the attribute delimiter, a caret rarely shows up in 1Password values
+	leefDelimiter = '^'
+	// the devTime format LEEF collectors expect, in Java SimpleDateFormat and Go notation
+	leefTimeFormat   = "MMM dd yyyy HH:mm:ss.SSS zzz"
+	leefGoTimeFormat = "Jan 02 2006 15:04:05.000 MST"
+)
+
+// CEF severities, collectors group them as 0-3 low, 4-6 medium, 7-8 high and 9-10 very high.
+const (
+	severityLow         = 3
+	severityMedium      = 5
+	severityFailedLogin = 6
+	severityHigh        = 8
+)
+
+// audit actions which remove access or data, as documented for the 1Password Events API.
+var destructiveActions = []string{"delete", "purge", "suspend", "revoke", "trash", "dsbl"}
+
+var streamNames = map[string]string{
+	onepassword.StreamSignins: "Sign-in attempt",
+	onepassword.StreamUsage:   "Item usage",
+	onepassword.StreamAudit:   "Audit event",
+}
+
+// event holds the fields of a converted log which are mapped onto CEF and LEEF, regardless of the table layout.
+type event struct {
+	stream   string
+	time     time.Time
+	uuid     string
+	action   string
+	outcome  string
+	severity int
+
+	account   string
+	userUUID  string
+	userName  string
+	userEmail string
+	ip        string
+	country   string
+	city      string
+
+	// the object an audit event acted upon, or the item that was used
+	objectType string
+	objectUUID string
+}
+
+func newEvent(stream string, log record.Record) (event, error) {
+	normalized, err := log.Normalize()
+	if err != nil {
+		return event{}, err
+	}
+
+	e := event{
+		stream:     stream,
+		uuid:       record.LookupString(normalized, "UUID", "Data.UUID"),
+		action:     record.LookupString(normalized, "Action", "Data.Action", "EventType", "Data.EventType"),
+		account:    record.LookupString(normalized, onepassword.AccountColumn),
+		userUUID:   record.LookupString(normalized, "ActorUUID", "Data.ActorUUID"),
+		userName:   record.LookupString(normalized, "ActorName", "Data.ActorName"),
+		userEmail:  record.LookupString(normalized, "ActorEmail", "Data.ActorEmail"),
+		ip:         record.LookupString(normalized, "IPAddress", "Client.ip_address", "SessionIP", "Data.SessionIP"),
+		country:    record.LookupString(normalized, "Country", "Data.Country"),
+		city:       record.LookupString(normalized, "City", "Data.City"),
+		objectType: record.LookupString(normalized, "ObjectType", "Data.ObjectType"),
+		objectUUID: record.LookupString(normalized, "ObjectUUID", "Data.ObjectUUID", "ItemUUID", "Data.ItemUUID"),
+	}
+
+	e.time = time.Now().UTC()
+	if timeGenerated := record.LookupString(normalized, "TimeGenerated"); timeGenerated != "" {
+		if e.time, err = onepassword.ParseTimeGenerated(timeGenerated); err != nil {
+			return event{}, fmt.Errorf("could not parse time of event %s: %v", e.uuid, err)
+		}
+	}
+
+	if ok, isBool := record.Lookup(normalized, "OK", "Data.OK").(bool); isBool {
+		e.outcome = "failure"
+		if ok {
+			e.outcome = "success"
+		}
+	}
+
+	if e.objectType == "" && e.objectUUID != "" {
+		e.objectType = "item"
+	}
+
+	// collectors reject a source address which is not an IP
+	if net.ParseIP(e.ip) == nil {
+		e.ip = ""
+	}
+
+	e.severity = e.computeSeverity()
+
+	return e, nil
+}
+
+func (e event) computeSeverity() int {
+	switch {
+	case e.outcome == "failure":
+		return severityFailedLogin
+	case e.stream == onepassword.StreamAudit && slices.Contains(destructiveActions, e.action):
+		return severityHigh
+	case e.stream == onepassword.StreamAudit:
+		return severityMedium
+	default:
+		return severityLow
+	}
+}
+
+// classID identifies the kind of event, e.g. signinattempts:credentials_ok.
+func (e event) classID() string {
+	if e.action == "" {
+		return e.stream
+	}
+
+	return e.stream + ":" + e.action
+}
+
+func (e event) name() string {
+	name, ok := streamNames[e.stream]
+	if !ok {
+		name = e.stream
+	}
+
+	if e.action == "" {
+		return name
+	}
+
+	return name + " " + e.action
+}
+
+func (e event) user() string {
+	if e.userEmail != "" {
+		return e.userEmail
+	}
+
+	return e.userName
+}
+
+// syslogSeverity maps a CEF severity onto the syslog severity of the message header.
+func syslogSeverity(severity int) int {
+	switch {
+	case severity >= 9:
+		return 2 // critical
+	case severity >= 7:
+		return 3 // error
+	case severity >= 4:
+		return 4 // warning
+	default:
+		return 6 // informational
+	}
+}
+
+// extension is an ordered list of key value pairs, empty values are left out when rendering.
+type extension [][2]string
+
+func (x *extension) add(key, value string) {
+	if value != "" {
+		*x = append(*x, [2]string{key, value})
+	}
+}
+
+// formatCEF renders the event as ArcSight Common Event Format.
+func formatCEF(e event) string {
+	var ext extension
+	ext.add("rt", strconv.FormatInt(e.time.UnixMilli(), 10))
+	ext.add("externalId", e.uuid)
+	ext.add("cat", e.stream)
+	ext.add("act", e.action)
+	ext.add("outcome", e.outcome)
+	ext.add("suser", e.user())
+	ext.add("suid", e.userUUID)
+	ext.add("src", e.ip)
+
+	if e.account != "" {
+		ext.add("cs1Label", "account")
+		ext.add("cs1", e.account)
+	}
+	if e.country != "" {
+		ext.add("cs2Label", "country")
+		ext.add("cs2", e.country)
+	}
+	if e.city != "" {
+		ext.add("cs3Label", "city")
+		ext.add("cs3", e.city)
+	}
+	if e.objectUUID != "" {
+		ext.add("cs4Label", "objectType")
+		ext.add("cs4", e.objectType)
+		ext.add("cs5Label", "objectUUID")
+		ext.add("cs5", e.objectUUID)
+	}
+
+	var b strings.Builder
+	b.WriteString("CEF:0")
+	for _, field := range []string{deviceVendor, deviceProduct, deviceVersion, e.classID(), e.name(), strconv.Itoa(e.severity)} {
+		b.WriteByte('|')
+		b.WriteString(escapeCEFHeader(field))
+	}
+	b.WriteByte('|')
+
+	for i, kv := range ext {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(kv[0])
+		b.WriteByte('=')
+		b.WriteString(escapeCEFValue(kv[1]))
+	}
+
+	return b.String()
+}
+
+// formatLEEF renders the event as IBM QRadar Log Event Extended Format 2.0.
+func formatLEEF(e event) string {
+	var ext extension
+	ext.add("devTime", e.time.UTC().Format(leefGoTimeFormat))
+	ext.add("devTimeFormat", leefTimeFormat)
+	ext.add("cat", e.stream)
+	// LEEF severities range from 1 to 10
+	ext.add("sev", strconv.Itoa(max(e.severity, 1)))
+	ext.add("externalId", e.uuid)
+	ext.add("action", e.action)
+	ext.add("outcome", e.outcome)
+	ext.add("usrName", e.user())
+	ext.add("userUUID", e.userUUID)
+	ext.add("src", e.ip)
+	ext.add("accountName", e.account)
+	ext.add("country", e.country)
+	ext.add("city", e.city)
+	ext.add("objectType", e.objectType)
+	ext.add("resource", e.objectUUID)
+
+	if e.stream == onepassword.StreamSignins && e.outcome == "success" {
+		ext.add("isLoginEvent", "true")
+	}
+
+	var b strings.Builder
+	b.WriteString("LEEF:2.0")
+	for _, field := range []string{deviceVendor, deviceProduct, deviceVersion, e.classID(), string(leefDelimiter)} {
+		b.WriteByte('|')
+		b.WriteString(escapeLEEFHeader(field))
+	}
+	b.WriteByte('|')
+
+	for i, kv := range ext {
+		if i > 0 {
+			b.WriteRune(leefDelimiter)
+		}
+		b.WriteString(kv[0])
+		b.WriteByte('=')
+		b.WriteString(escapeLEEFValue(kv[1]))
+	}
+
+	return b.String()
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+	cefValueEscaper  = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r\n", `\n`, "\n", `\n`, "\r", `\r`)
+
+	leefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r\n", " ", "\n", " ", "\r", " ")
+	leefValueEscaper  = strings.NewReplacer(`\`, `\\`, string(leefDelimiter), `\`+string(leefDelimiter),
+		"\r\n", `\n`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+)
+
+// escapeCEFHeader escapes backslashes and pipes in a CEF header field, newlines are not allowed there.
+func escapeCEFHeader(value string) string {
+	return cefHeaderEscaper.Replace(value)
+}
+
+// escapeCEFValue escapes backslashes, equal signs and newlines in a CEF extension value.
+func escapeCEFValue(value string) string {
+	return cefValueEscaper.Replace(value)
+}
+
+func escapeLEEFHeader(value string) string {
+	return leefHeaderEscaper.Replace(value)
+}
+
+// escapeLEEFValue escapes the attribute delimiter and control characters in a LEEF attribute value.
+func escapeLEEFValue(value string) string {
+	return leefValueEscaper.Replace(value)
+}
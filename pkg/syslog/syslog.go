@@ -0,0 +1,346 @@
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+
+	// octet counting prefixes every message with its length (RFC 6587), non transparent ends it with a newline
+	FramingOctetCounting  = "octet_counting"
+	FramingNonTransparent = "non_transparent"
+
+	defaultAppName      = "one2sen"
+	defaultFacility     = "local0"
+	defaultDialTimeout  = 10 * time.Second
+	defaultWriteTimeout = 30 * time.Second
+
+	// the timestamp format of RFC 5424, which is RFC 3339 with at most microseconds
+	rfc5424TimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+var facilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "audit": 13, "alert": 14, "clock": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Options configure the syslog sink.
+type Options struct {
+	// udp, tcp or tls
+	Network string
+	Address string
+	// cef or leef
+	Format string
+	// octet_counting or non_transparent, only used for tcp and tls
+	Framing string
+
+	// facility name such as local0 or authpriv
+	Facility string
+	// defaults to the hostname of the machine
+	Hostname string
+	AppName  string
+
+	// CA bundle to verify the collector with instead of the system roots
+	CAFile string
+	// client certificate and key, for collectors which require mutual TLS
+	CertFile   string
+	KeyFile    string
+	ServerName string
+
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	Retry        sink.Retry
+}
+
+// Writer sends logs as CEF or LEEF messages with RFC 5424 headers to a syslog collector.
+// The connection is opened on first use and opened again after a write failed.
+type Writer struct {
+	logger   *logrus.Logger
+	opts     Options
+	facility int
+
+	// serializes batches, so the messages of a batch are written in order on one connection
+	mu   sync.Mutex
+	conn net.Conn
+	// opens the connection, replaced in tests
+	dialFunc func(ctx context.Context) (net.Conn, error)
+}
+
+func New(logger *logrus.Logger, opts Options) (*Writer, error) {
+	if opts.Address == "" {
+		return nil, errors.New("no syslog address provided")
+	}
+
+	if opts.Network == "" {
+		opts.Network = NetworkTCP
+	}
+
+	if opts.Network != NetworkUDP && opts.Network != NetworkTCP && opts.Network != NetworkTLS {
+		return nil, fmt.Errorf("unknown syslog network '%s', use %s, %s or %s", opts.Network, NetworkUDP, NetworkTCP, NetworkTLS)
+	}
+
+	if opts.Format == "" {
+		opts.Format = FormatCEF
+	}
+
+	if opts.Format != FormatCEF && opts.Format != FormatLEEF {
+		return nil, fmt.Errorf("unknown syslog format '%s', use %s or %s", opts.Format, FormatCEF, FormatLEEF)
+	}
+
+	if opts.Framing == "" {
+		opts.Framing = FramingOctetCounting
+	}
+
+	if opts.Framing != FramingOctetCounting && opts.Framing != FramingNonTransparent {
+		return nil, fmt.Errorf("unknown syslog framing '%s', use %s or %s", opts.Framing, FramingOctetCounting, FramingNonTransparent)
+	}
+
+	if opts.Facility == "" {
+		opts.Facility = defaultFacility
+	}
+
+	facility, ok := facilities[strings.ToLower(opts.Facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility '%s'", opts.Facility)
+	}
+
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("a syslog client certificate needs both a certificate and key file")
+	}
+
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+
+	if opts.AppName == "" {
+		opts.AppName = defaultAppName
+	}
+
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = defaultWriteTimeout
+	}
+
+	w := &Writer{
+		logger:   logger,
+		opts:     opts,
+		facility: facility,
+	}
+	w.dialFunc = w.dial
+
+	return w, nil
+}
+
+func (w *Writer) Name() string {
+	return "syslog"
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.disconnect()
+}
+
+// Send writes every log of batch as a single syslog message.
+// When writing fails the connection is opened again and the remaining messages are retried.
+func (w *Writer) Send(ctx context.Context, batch sink.Batch) error {
+	messages := make([][]byte, 0, len(batch.Logs))
+
+	for _, log := range batch.Logs {
+		e, err := newEvent(batch.Stream, log)
+		if err != nil {
+			return err
+		}
+
+		messages = append(messages, w.frame(w.message(e)))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// a failed write closes the connection, so the next attempt reconnects and continues with the remaining messages
+	err := w.opts.Retry.Do(ctx, w.logger, "syslog", func() error {
+		written, err := w.write(ctx, messages)
+		messages = messages[written:]
+
+		if err != nil {
+			return fmt.Errorf("%d messages were not sent: %w", len(messages), err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.logger.WithField("module", "syslog").WithField("stream", batch.Stream).
+		WithField("total", len(batch.Logs)).Debug("sent logs")
+
+	return nil
+}
+
+// message renders the event with an RFC 5424 header, the stream is used as message id.
+func (w *Writer) message(e event) string {
+	body := formatCEF(e)
+	if w.opts.Format == FormatLEEF {
+		body = formatLEEF(e)
+	}
+
+	priority := w.facility*8 + syslogSeverity(e.severity)
+
+	return fmt.Sprintf("<%d>1 %s %s %s - %s - %s",
+		priority,
+		e.time.UTC().Format(rfc5424TimeFormat),
+		headerField(w.opts.Hostname, 255),
+		headerField(w.opts.AppName, 48),
+		headerField(e.stream, 32),
+		body)
+}
+
+// frame delimits a message on stream transports, every UDP datagram already holds exactly one message.
+func (w *Writer) frame(message string) []byte {
+	switch {
+	case w.opts.Network == NetworkUDP:
+		return []byte(message)
+	case w.opts.Framing == FramingNonTransparent:
+		return []byte(message + "\n")
+	default:
+		return []byte(strconv.Itoa(len(message)) + " " + message)
+	}
+}
+
+// write writes messages until one fails and returns how many were written.
+// A failed connection is closed, so the next write opens a new one.
+func (w *Writer) write(ctx context.Context, messages [][]byte) (int, error) {
+	if w.conn == nil {
+		conn, err := w.dialFunc(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		w.conn = conn
+	}
+
+	for i, message := range messages {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.opts.WriteTimeout)); err != nil {
+			_ = w.disconnect()
+			return i, fmt.Errorf("could not set write deadline: %v", err)
+		}
+
+		if _, err := w.conn.Write(message); err != nil {
+			_ = w.disconnect()
+			return i, fmt.Errorf("could not write to syslog collector: %v", err)
+		}
+	}
+
+	return len(messages), nil
+}
+
+func (w *Writer) disconnect() error {
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+// dial connects to the collector, certificates are loaded on every dial so renewed files are picked up.
+func (w *Writer) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: w.opts.DialTimeout}
+
+	if w.opts.Network != NetworkTLS {
+		conn, err := dialer.DialContext(ctx, w.opts.Network, w.opts.Address)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to syslog collector: %v", err)
+		}
+
+		return conn, nil
+	}
+
+	tlsConfig, err := w.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+
+	conn, err := tlsDialer.DialContext(ctx, "tcp", w.opts.Address)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to syslog collector: %v", err)
+	}
+
+	return conn, nil
+}
+
+func (w *Writer) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: w.opts.ServerName,
+	}
+
+	if w.opts.CAFile != "" {
+		pem, err := os.ReadFile(w.opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read syslog ca file: %v", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", w.opts.CAFile)
+		}
+	}
+
+	if w.opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(w.opts.CertFile, w.opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load syslog client certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// headerField makes a value fit an RFC 5424 header field, which is printable ASCII without spaces.
+func headerField(value string, maxLength int) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, value)
+
+	if value == "" {
+		return "-"
+	}
+
+	if len(value) > maxLength {
+		value = value[:maxLength]
+	}
+
+	return value
+}
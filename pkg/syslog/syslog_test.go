@@ -0,0 +1,198 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		escape func(string) string
+		value  string
+		want   string
+	}{
+		{escapeCEFHeader, `a|b\c`, `a\|b\\c`},
+		{escapeCEFHeader, "a=b\nc", `a=b c`},
+		{escapeCEFValue, `a|b=c\d`, `a|b\=c\\d`},
+		{escapeCEFValue, "line\r\nnext", `line\nnext`},
+		{escapeLEEFValue, `a^b=c\d`, `a\^b=c\\d`},
+		{escapeLEEFValue, "a\tb\nc", `a\tb\nc`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.escape(tt.value); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	signins, err := onepassword.ConvertSigninToMap(nil, []onepassword.Event{
+		{UUID: "e1", Timestamp: "2024-01-02T03:04:05Z", Type: "credentials_ok", Client: onepassword.Client{IPAddress: "192.0.2.1"}},
+		{UUID: "e2", Timestamp: "2024-01-02T03:04:06Z", Type: "mfa_failed", TargetUser: onepassword.TargetUser{Email: "jane=doe@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audits, err := onepassword.ConvertAuditEventToFlatMap(nil, []onepassword.AuditEvent{
+		{UUID: "a1", Timestamp: "2024-01-02T03:04:07Z", Action: "delete", ObjectType: "vault", ObjectUUID: "v1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audits[0][onepassword.AccountColumn] = "eu|prod"
+
+	tests := []struct {
+		stream string
+		log    record.Record
+		format func(event) string
+		want   string
+	}{
+		{onepassword.StreamSignins, signins[0], formatCEF,
+			`CEF:0|1Password|Events API|1|signinattempts:credentials_ok|Sign-in attempt credentials_ok|3|rt=1704164645000 externalId=e1 cat=signinattempts act=credentials_ok outcome=success src=192.0.2.1`},
+		{onepassword.StreamSignins, signins[1], formatCEF,
+			`CEF:0|1Password|Events API|1|signinattempts:mfa_failed|Sign-in attempt mfa_failed|6|rt=1704164646000 externalId=e2 cat=signinattempts act=mfa_failed outcome=failure suser=jane\=doe@example.com`},
+		{onepassword.StreamAudit, audits[0], formatCEF,
+			`CEF:0|1Password|Events API|1|auditevents:delete|Audit event delete|8|rt=1704164647000 externalId=a1 cat=auditevents act=delete cs1Label=account cs1=eu|prod cs4Label=objectType cs4=vault cs5Label=objectUUID cs5=v1`},
+		{onepassword.StreamAudit, audits[0], formatLEEF,
+			`LEEF:2.0|1Password|Events API|1|auditevents:delete|^|devTime=Jan 02 2024 03:04:07.000 UTC^devTimeFormat=MMM dd yyyy HH:mm:ss.SSS zzz^cat=auditevents^sev=8^externalId=a1^action=delete^accountName=eu|prod^objectType=vault^resource=v1`},
+	}
+
+	for _, tt := range tests {
+		e, err := newEvent(tt.stream, tt.log)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := tt.format(e); got != tt.want {
+			t.Errorf("unexpected message:\n got %s\nwant %s", got, tt.want)
+		}
+	}
+}
+
+// failingConn drops the connection after a number of writes, like a collector going away in the middle of a batch.
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	if c.writes == 0 {
+		_ = c.Conn.Close()
+		return 0, errors.New("connection reset by peer")
+	}
+
+	c.writes--
+
+	return c.Conn.Write(p)
+}
+
+func TestWriter_Send(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 10)
+	connections := make(chan struct{}, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			connections <- struct{}{}
+
+			// read octet counted frames
+			reader := bufio.NewReader(conn)
+			for {
+				length, err := reader.ReadString(' ')
+				if err != nil {
+					break
+				}
+
+				size, err := strconv.Atoi(strings.TrimSpace(length))
+				if err != nil {
+					break
+				}
+
+				message := make([]byte, size)
+				if _, err := io.ReadFull(reader, message); err != nil {
+					break
+				}
+
+				received <- string(message)
+			}
+
+			_ = conn.Close()
+		}
+	}()
+
+	writer, err := New(logrus.New(), Options{Address: listener.Addr().String(), Hostname: "host", Facility: "authpriv", Retry: sink.Retry{BaseDelay: time.Millisecond}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first connection is dropped after two of the four messages were written
+	dials := 0
+	writer.dialFunc = func(ctx context.Context) (net.Conn, error) {
+		conn, err := writer.dial(ctx)
+		if err != nil || dials > 0 {
+			return conn, err
+		}
+
+		dials++
+
+		return &failingConn{Conn: conn, writes: 2}, nil
+	}
+
+	var events []onepassword.Event
+	for i := 1; i <= 4; i++ {
+		events = append(events, onepassword.Event{UUID: "e" + strconv.Itoa(i), Timestamp: "2024-01-02T03:04:05Z", Type: "credentials_failed"})
+	}
+
+	signins, err := onepassword.ConvertSigninToFlatMap(nil, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Send(context.Background(), sink.Batch{Stream: onepassword.StreamSignins, Logs: signins}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<84>1 2024-01-02T03:04:05.000000Z host one2sen - signinattempts - CEF:0|1Password|Events API|1|signinattempts:credentials_failed|"
+
+	for i := 1; i <= len(events); i++ {
+		select {
+		case message := <-received:
+			if !strings.HasPrefix(message, want) || !strings.Contains(message, "externalId=e"+strconv.Itoa(i)+" ") {
+				t.Fatalf("unexpected message %d: %s", i, message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d was not received", i)
+		}
+	}
+
+	if len(connections) != 2 {
+		t.Fatalf("expected the writer to reconnect once, got %d connections", len(connections))
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
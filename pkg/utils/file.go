@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces the file at path with contents.
+// The contents are written to a temporary file first, so a crash never leaves a truncated file behind.
+func WriteFileAtomic(path string, contents []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+
+	if _, err := tmpFile.Write(contents); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return fmt.Errorf("could not write temporary file: %v", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return fmt.Errorf("could not close temporary file: %v", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return fmt.Errorf("could not replace '%s': %v", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/one2sen/pkg/record"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/hazcod/one2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// ModeBatch sends all logs of a page in one request, ModeEvent sends a request per log.
+	ModeBatch = "batch"
+	ModeEvent = "event"
+
+	defaultMethod          = http.MethodPost
+	defaultContentType     = "application/json"
+	defaultSignatureHeader = "X-Signature-256"
+	defaultMaxBatchBytes   = 1000 * 1000
+
+	// the timestamp which is part of the signed content, so a captured request can not be replayed later
+	timestampHeader = "X-Signature-Timestamp"
+
+	defaultBatchTemplate = `{{ json .Logs }}`
+	defaultEventTemplate = `{{ json .Log }}`
+)
+
+// Options configure the webhook sink.
+type Options struct {
+	URL    string
+	Method string
+	// batch or event
+	Mode string
+
+	// text/template for the request body, see Data for the available fields
+	Template    string
+	ContentType string
+	Headers     map[string]secret.Func
+
+	// sign the body with HMAC-SHA256, the hex encoded signature is sent as sha256=<signature>
+	HMACSecret      secret.Func
+	SignatureHeader string
+
+	// in batch mode, logs are split into requests of at most this many bytes of JSON
+	MaxBatchBytes int
+	Retry         sink.Retry
+}
+
+// Data is what the body template is executed with.
+type Data struct {
+	Account string
+	Stream  string
+	// the log in event mode, with nested objects as maps
+	Log map[string]any
+	// the logs in batch mode
+	Logs []map[string]any
+}
+
+// Webhook sends logs to an HTTP endpoint with a templated body.
+type Webhook struct {
+	logger     *logrus.Logger
+	opts       Options
+	template   *template.Template
+	httpClient *http.Client
+}
+
+var templateFuncs = template.FuncMap{
+	// json encodes a value, so strings are quoted and escaped
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	// lookup returns the first non-empty field out of the dotted paths, e.g. lookup .Log "ActorEmail" "Data.ActorEmail"
+	"lookup": record.Lookup,
+}
+
+func New(logger *logrus.Logger, opts Options) (*Webhook, error) {
+	if opts.URL == "" {
+		return nil, errors.New("no webhook url provided")
+	}
+
+	if opts.Method == "" {
+		opts.Method = defaultMethod
+	}
+
+	if opts.Mode == "" {
+		opts.Mode = ModeBatch
+	}
+
+	if opts.Mode != ModeBatch && opts.Mode != ModeEvent {
+		return nil, fmt.Errorf("unknown webhook mode '%s', use %s or %s", opts.Mode, ModeBatch, ModeEvent)
+	}
+
+	if opts.Template == "" {
+		opts.Template = defaultBatchTemplate
+		if opts.Mode == ModeEvent {
+			opts.Template = defaultEventTemplate
+		}
+	}
+
+	if opts.ContentType == "" {
+		opts.ContentType = defaultContentType
+	}
+
+	if opts.SignatureHeader == "" {
+		opts.SignatureHeader = defaultSignatureHeader
+	}
+
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = defaultMaxBatchBytes
+	}
+
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Option("missingkey=zero").Parse(opts.Template)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse webhook template: %v", err)
+	}
+
+	return &Webhook{
+		logger:     logger,
+		opts:       opts,
+		template:   tmpl,
+		httpClient: utils.NewLogHttpClient(logger),
+	}, nil
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Close() error {
+	return nil
+}
+
+// Send renders and sends the logs of batch, as one request per log in event mode or split by size in batch mode.
+func (w *Webhook) Send(ctx context.Context, batch sink.Batch) error {
+	logs := make([]sizedLog, 0, len(batch.Logs))
+
+	for _, log := range batch.Logs {
+		normalized, err := log.Normalize()
+		if err != nil {
+			return err
+		}
+
+		// the size of the JSON encoding is a good enough estimate of the rendered size
+		encoded, _ := json.Marshal(normalized)
+
+		logs = append(logs, sizedLog{log: normalized, size: len(encoded)})
+	}
+
+	var bodies [][]byte
+
+	if w.opts.Mode == ModeEvent {
+		for _, log := range logs {
+			body, err := w.render(Data{Account: batch.Account, Stream: batch.Stream, Log: log.log})
+			if err != nil {
+				return err
+			}
+
+			bodies = append(bodies, body)
+		}
+	} else {
+		for _, chunk := range sink.Chunk(logs, w.opts.MaxBatchBytes, func(log sizedLog) int { return log.size }) {
+			data := Data{Account: batch.Account, Stream: batch.Stream, Logs: make([]map[string]any, len(chunk))}
+			for i, log := range chunk {
+				data.Logs[i] = log.log
+			}
+
+			body, err := w.render(data)
+			if err != nil {
+				return err
+			}
+
+			bodies = append(bodies, body)
+		}
+	}
+
+	for _, body := range bodies {
+		if err := w.opts.Retry.Do(ctx, w.logger, "webhook", func() error { return w.post(ctx, body) }); err != nil {
+			return err
+		}
+	}
+
+	w.logger.WithField("module", "webhook").WithField("stream", batch.Stream).WithField("total", len(batch.Logs)).
+		WithField("requests", len(bodies)).Debug("sent logs to webhook")
+
+	return nil
+}
+
+type sizedLog struct {
+	log  map[string]any
+	size int
+}
+
+func (w *Webhook) render(data Data) ([]byte, error) {
+	var body bytes.Buffer
+	if err := w.template.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("could not render webhook template: %v", err)
+	}
+
+	// catch templates which produce broken JSON before the receiver does
+	if strings.HasPrefix(w.opts.ContentType, defaultContentType) && !json.Valid(body.Bytes()) {
+		return nil, errors.New("webhook template did not render valid JSON")
+	}
+
+	return body.Bytes(), nil
+}
+
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, w.opts.Method, w.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return sink.Permanent(fmt.Errorf("could not create webhook request: %v", err))
+	}
+
+	req.Header.Set("Content-Type", w.opts.ContentType)
+
+	for name, value := range w.opts.Headers {
+		resolved, err := value(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get webhook header %s: %v", name, err)
+		}
+
+		req.Header.Set(name, resolved)
+	}
+
+	if w.opts.HMACSecret != nil {
+		if err := w.sign(ctx, req, body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not reach webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook rejected the request: %w", sink.NewStatusError(resp, respBody))
+	}
+
+	return nil
+}
+
+// sign adds the HMAC-SHA256 of "<timestamp>.<body>", which receivers verify with the shared secret.
+func (w *Webhook) sign(ctx context.Context, req *http.Request, body []byte) error {
+	key, err := w.opts.HMACSecret(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get webhook signing secret: %v", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(w.opts.SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	return nil
+}
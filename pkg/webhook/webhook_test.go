@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hazcod/one2sen/pkg/onepassword"
+	"github.com/hazcod/one2sen/pkg/secret"
+	"github.com/hazcod/one2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhook_Send(t *testing.T) {
+	var requests []map[string]any
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		// the first attempt fails, which has to be retried
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		mac := hmac.New(sha256.New, []byte("signing-key"))
+		mac.Write([]byte(r.Header.Get("X-Signature-Timestamp") + "."))
+		mac.Write(body)
+
+		if r.Header.Get("X-Signature-256") != "sha256="+hex.EncodeToString(mac.Sum(nil)) || r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var payload map[string]any
+		if err := json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		requests = append(requests, payload)
+	}))
+	defer server.Close()
+
+	webhook, err := New(logrus.New(), Options{
+		URL:        server.URL,
+		Mode:       ModeEvent,
+		Template:   `{"account": {{ json .Account }}, "user": {{ json (lookup .Log "ActorEmail" "Data.ActorEmail") }}, "action": {{ json .Log.Action }}}`,
+		Headers:    map[string]secret.Func{"Authorization": secret.Static("Bearer tok")},
+		HMACSecret: secret.Static("signing-key"),
+		Retry:      sink.Retry{BaseDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audits, err := onepassword.ConvertAuditEventToFlatMap(nil, []onepassword.AuditEvent{
+		{UUID: "a1", Timestamp: "2024-01-02T03:04:05Z", Action: "delete", ActorDetails: onepassword.ActorDetails{Email: `jane"doe@example.com`}},
+		{UUID: "a2", Timestamp: "2024-01-02T03:04:06Z", Action: "create"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := webhook.Send(context.Background(), sink.Batch{Account: "eu", Stream: onepassword.StreamAudit, Logs: audits}); err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts != 3 || len(requests) != 2 {
+		t.Fatalf("expected 2 requests after 3 attempts, got %d requests after %d attempts", len(requests), attempts)
+	}
+
+	if requests[0]["account"] != "eu" || requests[0]["user"] != `jane"doe@example.com` || requests[0]["action"] != "delete" {
+		t.Fatalf("unexpected payload: %v", requests[0])
+	}
+
+	// an event without actor renders the zero value
+	if requests[1]["user"] != nil || requests[1]["action"] != "create" {
+		t.Fatalf("unexpected payload: %v", requests[1])
+	}
+}
+
+func TestWebhook_SendBatch(t *testing.T) {
+	var batches [][]map[string]any
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var logs []map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&logs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		batches = append(batches, logs)
+	}))
+	defer server.Close()
+
+	// small enough to fit a single log per request
+	webhook, err := New(logrus.New(), Options{URL: server.URL, MaxBatchBytes: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usages, err := onepassword.ConvertUsageToFlatMap(nil, []onepassword.Item{
+		{UUID: "u1", Timestamp: "2024-01-02T03:04:05Z"},
+		{UUID: "u2", Timestamp: "2024-01-02T03:04:06Z"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := webhook.Send(context.Background(), sink.Batch{Stream: onepassword.StreamUsage, Logs: usages}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batches) != 2 || batches[1][0]["UUID"] != "u2" {
+		t.Fatalf("expected the logs to be split over 2 requests: %v", batches)
+	}
+}